@@ -1,13 +1,35 @@
 package main
 
 import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
+	"io"
+	"math"
 	"math/rand"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	_ "image/png"
+
 	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
 	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
 )
 
 const (
@@ -17,6 +39,15 @@ const (
 	delay  = 32 * time.Millisecond
 )
 
+var (
+	palettes = []Palette{doom}
+
+	// maskImage is scaled into a Mask that is used
+	// as specified by maskMode, if there is one
+	maskImage image.Image
+	maskMode  string
+)
+
 func run() {
 	win, err := pixelgl.NewWindow(pixelgl.WindowConfig{
 		Bounds:      pixel.R(0, 0, float64(width)*scale, float64(height)*scale),
@@ -28,23 +59,102 @@ func run() {
 		panic(err)
 	}
 
-	i := NewInferno(width, height)
+	i := newInferno(width, height)
+
+	current := 0
 	c := win.Bounds().Center()
+	mat := pixel.IM.Moved(c).Scaled(c, scale*1.1)
 
-	ticker := time.NewTicker(delay)
+	var (
+		e   editor
+		imd = imdraw.New(nil)
+		txt = text.New(pixel.V(10, win.Bounds().H()-30), text.Atlas7x13)
+	)
 
-	go func() {
-		for range ticker.C {
-			i.Spread()
-			i.Render()
-		}
-	}()
+	// Step the fire at a fixed rate, no matter the frame rate
+	var (
+		last = time.Now()
+		adt  time.Duration
+	)
 
 	for !win.Closed() {
-		p := pixel.PictureDataFromImage(i.buffer)
+		adt += time.Since(last)
+		last = time.Now()
+
+		if win.JustPressed(pixelgl.KeyTab) {
+			e.open = !e.open
+		}
+
+		// The arrow keys edit the palette while the editor is open
+		if e.open {
+			palettes[current] = e.update(win, palettes[current])
+
+			i.SetPalette(palettes[current])
+		} else {
+			if win.Pressed(pixelgl.KeyLeft) {
+				i.SetWind(i.wind - 0.02)
+			}
+
+			if win.Pressed(pixelgl.KeyRight) {
+				i.SetWind(i.wind + 0.02)
+			}
+		}
 
-		pixel.NewSprite(p, p.Bounds()).
-			Draw(win, pixel.IM.Moved(c).Scaled(c, scale*1.1))
+		if win.JustPressed(pixelgl.KeyE) {
+			i.Extinguish()
+		}
+
+		if win.JustPressed(pixelgl.KeyI) {
+			i.Ignite()
+		}
+
+		if win.JustPressed(pixelgl.KeyBackspace) {
+			i.ClearSources()
+		}
+
+		if win.JustPressed(pixelgl.KeyP) {
+			current = (current + 1) % len(palettes)
+
+			i.SetPalette(palettes[current])
+
+			e.selected = 0
+		}
+
+		// Paint fire sources with the left mouse button, remove them with the right
+		if l, r := win.Pressed(pixelgl.MouseButtonLeft), win.Pressed(pixelgl.MouseButtonRight); l || r {
+			p := mat.Unproject(win.MousePosition())
+			x, y := int(p.X+width/2), int(height/2-p.Y)
+
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if l {
+						i.AddSource(x+dx, y+dy, i.MaxIntensity())
+					} else {
+						i.AddSource(x+dx, y+dy, 0)
+					}
+				}
+			}
+		}
+
+		// Catch up with at most a few steps after a stall, such as a window drag
+		if adt > 4*delay {
+			adt = 4 * delay
+		}
+
+		for ; adt >= delay; adt -= delay {
+			i.Step()
+		}
+
+		i.View(func(m *image.RGBA) {
+			p := pixel.PictureDataFromImage(m)
+
+			pixel.NewSprite(p, p.Bounds()).
+				Draw(win, mat)
+		})
+
+		if e.open {
+			e.draw(win, imd, txt, palettes[current])
+		}
 
 		if win.JustPressed(pixelgl.KeyEscape) || win.JustPressed(pixelgl.KeyQ) {
 			return
@@ -54,19 +164,306 @@ func run() {
 	}
 }
 
+// newInferno returns an inferno using the first palette and the mask
+func newInferno(width, height int) *Inferno {
+	i := NewInferno(width, height)
+	i.SetPalette(palettes[0])
+
+	if maskImage != nil {
+		i.SetMask(NewMask(maskImage, width, height), maskMode)
+	}
+
+	return i
+}
+
+// runTerm renders the fire to w using half blocks and 24-bit ANSI
+// colors, until interrupted or until frames have been rendered
+func runTerm(w io.Writer, cols, frames int) error {
+	i := newInferno(cols, cols*height/width)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	bw := bufio.NewWriter(w)
+
+	// Clear the screen and hide the cursor, showing it again when done
+	fmt.Fprint(bw, "\x1b[2J\x1b[?25l")
+	defer func() {
+		fmt.Fprint(bw, "\x1b[0m\x1b[?25h\n")
+		bw.Flush()
+	}()
+
+	ticker := time.NewTicker(delay)
+	defer ticker.Stop()
+
+	for n := 0; frames <= 0 || n < frames; n++ {
+		i.Step()
+		i.View(func(m *image.RGBA) {
+			writeHalfBlocks(bw, m)
+		})
+
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+
+		select {
+		case <-interrupt:
+			return nil
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// writeHalfBlocks writes two rows of m per line of text, the upper
+// half block is colored by the top row and its background by the bottom
+// row. Colors are only written when they change.
+func writeHalfBlocks(w io.Writer, m *image.RGBA) {
+	var (
+		b      = m.Bounds()
+		fg, bg color.RGBA
+	)
+
+	fmt.Fprint(w, "\x1b[H")
+
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			top, bottom := m.RGBAAt(x, y), m.RGBAAt(x, y+1)
+
+			if (x == b.Min.X) || top != fg {
+				fmt.Fprintf(w, "\x1b[38;2;%d;%d;%dm", top.R, top.G, top.B)
+			}
+
+			if (x == b.Min.X) || bottom != bg {
+				fmt.Fprintf(w, "\x1b[48;2;%d;%d;%dm", bottom.R, bottom.G, bottom.B)
+			}
+
+			fg, bg = top, bottom
+
+			fmt.Fprint(w, "▀")
+		}
+
+		fmt.Fprint(w, "\x1b[0m\r\n")
+	}
+}
+
+// editor changes the colors of a palette, and the number of them,
+// with the result showing in the fire right away
+type editor struct {
+	open     bool
+	selected int
+	channel  int // 0, 1 or 2 for red, green or blue
+}
+
+// update handles the keys of the editor, returning the edited palette.
+// The colors are edited in a copy, leaving p, which might be doom, as is.
+func (e *editor) update(win *pixelgl.Window, p Palette) Palette {
+	p = append(Palette(nil), p...)
+
+	switch {
+	case win.JustPressed(pixelgl.KeyLeft):
+		e.selected--
+	case win.JustPressed(pixelgl.KeyRight):
+		e.selected++
+	case win.JustPressed(pixelgl.KeyR):
+		e.channel = 0
+	case win.JustPressed(pixelgl.KeyG):
+		e.channel = 1
+	case win.JustPressed(pixelgl.KeyB):
+		e.channel = 2
+	case win.JustPressed(pixelgl.KeyEqual):
+		// Insert a copy of the selected color after it
+		p = append(p[:e.selected+1:e.selected+1], p[e.selected:]...)
+		e.selected++
+	case win.JustPressed(pixelgl.KeyMinus) && len(p) > 2:
+		p = append(p[:e.selected:e.selected], p[e.selected+1:]...)
+	case win.JustPressed(pixelgl.KeyS):
+		fn := fmt.Sprintf("doomfire-%d.gpl", time.Now().Unix())
+
+		if err := SavePalette(fn, p); err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Println("saved", fn)
+		}
+	}
+
+	if e.selected < 0 {
+		e.selected = 0
+	}
+
+	if e.selected >= len(p) {
+		e.selected = len(p) - 1
+	}
+
+	c := &p[e.selected]
+	v := [...]*uint8{&c.R, &c.G, &c.B}[e.channel]
+
+	if win.Pressed(pixelgl.KeyUp) && *v < 255 {
+		*v++
+	}
+
+	if win.Pressed(pixelgl.KeyDown) && *v > 0 {
+		*v--
+	}
+
+	return p
+}
+
+// draw draws the palette as swatches along the bottom
+// of the window, and the channels of the selected color
+func (e *editor) draw(win *pixelgl.Window, imd *imdraw.IMDraw, txt *text.Text, p Palette) {
+	w := win.Bounds().W() / float64(len(p))
+
+	imd.Clear()
+
+	for j, c := range p {
+		imd.Color = color.RGBA{c.R, c.G, c.B, 255}
+		imd.Push(pixel.V(float64(j)*w, 0), pixel.V(float64(j+1)*w, 32))
+		imd.Rectangle(0)
+	}
+
+	imd.Color = color.RGBA{255, 255, 255, 255}
+	imd.Push(pixel.V(float64(e.selected)*w, 0), pixel.V(float64(e.selected+1)*w, 32))
+	imd.Rectangle(2)
+
+	imd.Draw(win)
+
+	c := p[e.selected]
+
+	txt.Clear()
+
+	fmt.Fprintf(txt, "color %d of %d\n", e.selected, len(p)-1)
+
+	for j, v := range [...]uint8{c.R, c.G, c.B} {
+		cursor := " "
+
+		if j == e.channel {
+			cursor = ">"
+		}
+
+		fmt.Fprintf(txt, "%s %c %3d\n", cursor, "RGB"[j], v)
+	}
+
+	txt.Draw(win, pixel.IM.Scaled(txt.Orig, 2))
+}
+
 func main() {
+	var (
+		fn, mfn, txt, dir string
+
+		term   bool
+		cols   int
+		frames int
+	)
+
+	flag.StringVar(&fn, "palette", "", "palette to start with, a PNG strip or a text file with one color per line")
+	flag.StringVar(&dir, "palettes", paletteDir(), "directory of palettes to cycle through")
+	flag.StringVar(&mfn, "mask", "", "monochrome image used as a mask")
+	flag.StringVar(&txt, "text", "", "text used as a mask")
+	flag.StringVar(&maskMode, "mask-mode", "source", "use the mask as fire source, to occlude the fire, or both")
+	flag.BoolVar(&term, "term", false, "render to the terminal instead of a window")
+	flag.IntVar(&cols, "cols", 80, "number of columns used by -term")
+	flag.IntVar(&frames, "frames", 0, "number of frames rendered by -term before exiting, 0 to run until interrupted")
+	flag.Parse()
+
+	if term && cols < 2 {
+		fmt.Fprintf(os.Stderr, "-cols must be at least 2, not %d\n", cols)
+		os.Exit(1)
+	}
+
+	switch maskMode {
+	case "source", "occlude", "both":
+	default:
+		fmt.Fprintf(os.Stderr, "unknown mask mode %q\n", maskMode)
+		os.Exit(1)
+	}
+
+	if mfn != "" {
+		m, err := loadImage(mfn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", mfn, err)
+			os.Exit(1)
+		}
+
+		maskImage = m
+	}
+
+	if txt != "" {
+		maskImage = textImage(txt)
+	}
+
+	// Shipped palettes, cycled through with P
+	fns, _ := filepath.Glob(filepath.Join(dir, "*"))
+
+	for _, fn := range fns {
+		switch strings.ToLower(filepath.Ext(fn)) {
+		case ".gpl", ".txt", ".png":
+		default:
+			continue
+		}
+
+		p, err := LoadPalette(fn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s: %v\n", fn, err)
+
+			continue
+		}
+
+		palettes = append(palettes, p)
+	}
+
+	if fn != "" {
+		palettes = append([]Palette{mustLoadPalette(fn)}, palettes...)
+	}
+
+	if term {
+		if err := runTerm(os.Stdout, cols, frames); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	pixelgl.Run(run)
 }
 
+// Inferno is double buffered, Step renders into the back buffer
+// while the front buffer can be read using View
 type Inferno struct {
 	width  int
 	height int
-	grid   []int8
-	buffer *image.RGBA
+	grid   []int
+
+	// palette decides the max intensity of the fire, which
+	// is the index of its last and hottest color
+	palette Palette
+
+	// sources keep the cells they are set for burning at
+	// their intensity, scaled by fuel which is between 0 and 1
+	sources []int
+	fuel    float64
+	burning bool
+
+	// wind is between -1 (blowing left) and 1 (blowing right)
+	wind float64
+
+	// occluded cells never burn
+	occluded Mask
+
+	// mask is kept to restore its sources when clearing the painted ones
+	mask     Mask
+	maskMode string
+
+	mu     sync.Mutex
+	buffer *image.RGBA // front
+	back   *image.RGBA
 }
 
 func NewInferno(width, height int) *Inferno {
-	i := &Inferno{width: width, height: height}
+	i := &Inferno{width: width, height: height, palette: doom}
 
 	i.init()
 
@@ -74,26 +471,164 @@ func NewInferno(width, height int) *Inferno {
 }
 
 func (i *Inferno) init() {
-	i.grid = make([]int8, i.width*i.height)
+	i.grid = make([]int, i.width*i.height)
+	i.sources = make([]int, i.width*i.height)
+	i.occluded = make(Mask, i.width*i.height)
+	i.fuel = 1
+	i.burning = true
 
-	for j := 0; j < i.width; j++ {
-		i.grid[((i.height-1)*i.width)+j] = 42
-	}
+	i.resetSources()
+
+	i.feed()
 
 	i.buffer = image.NewRGBA(image.Rect(0, 0, i.width, i.height))
+	i.back = image.NewRGBA(image.Rect(0, 0, i.width, i.height))
 }
 
-func (i *Inferno) Render() {
-	for y := 0; y < i.height; y += 2 {
-		for x := 0; x < i.width; x++ {
-			if pos := (y * i.width) + x; pos > 0 && i.grid[pos] != i.grid[pos-1] {
-				i.buffer.Set(x, y, mapColor(i.grid[pos]))
+// Step spreads the fire, renders it into the back buffer and swaps the
+// buffers. Only one goroutine may call Step, but View may be called
+// concurrently from another.
+func (i *Inferno) Step() {
+	i.feed()
+	i.Spread()
+	i.Render()
+
+	i.mu.Lock()
+	i.buffer, i.back = i.back, i.buffer
+	i.mu.Unlock()
+}
+
+// View calls fn with the last rendered frame, which must not be
+// retained after fn returns since it is rendered into again by Step
+func (i *Inferno) View(fn func(m *image.RGBA)) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	fn(i.buffer)
+}
+
+// Ignite makes the fire burn up again gradually
+func (i *Inferno) Ignite() {
+	i.burning = true
+}
+
+// Extinguish makes the fire die down gradually
+func (i *Inferno) Extinguish() {
+	i.burning = false
+}
+
+// SetWind sets the wind, between -1 (blowing left) and 1 (blowing right)
+func (i *Inferno) SetWind(w float64) {
+	i.wind = math.Max(-1, math.Min(1, w))
+}
+
+// AddSource makes x, y burn at intensity v, or stop burning if v is 0
+func (i *Inferno) AddSource(x, y, v int) {
+	if x < 0 || y < 0 || x >= i.width || y >= i.height {
+		return
+	}
+
+	pos := (y * i.width) + x
+
+	i.sources[pos] = v
+	i.grid[pos] = 0
+}
+
+// MaxIntensity is the intensity of the hottest color in the palette
+func (i *Inferno) MaxIntensity() int {
+	return len(i.palette) - 1
+}
+
+// SetPalette changes the palette, scaling the intensity of
+// the fire to the number of colors in the new palette
+func (i *Inferno) SetPalette(p Palette) {
+	old := i.MaxIntensity()
+
+	i.palette = p
+
+	for pos := range i.grid {
+		i.grid[pos] = i.grid[pos] * i.MaxIntensity() / old
+		i.sources[pos] = i.sources[pos] * i.MaxIntensity() / old
+	}
+}
+
+// SetMask uses the mask as mode says, either as the only fire source,
+// to occlude the fire, or both, with the fire rising from the top edge
+// of the shape in the mask
+func (i *Inferno) SetMask(m Mask, mode string) {
+	i.mask, i.maskMode = m, mode
+
+	if mode == "source" || mode == "both" {
+		for pos := range i.sources {
+			i.sources[pos] = 0
+		}
+	}
+
+	for pos, set := range m {
+		switch mode {
+		case "source":
+			if set {
+				i.sources[pos] = i.MaxIntensity()
 			}
+		case "occlude":
+			i.occluded[pos] = set
+		case "both":
+			i.occluded[pos] = set
 
-			if pos := ((y + 1) * i.width) + x; i.grid[pos] != i.grid[pos-1] {
-				i.buffer.Set(x, y+1, mapColor(i.grid[pos]))
+			if below := pos + i.width; !set && below < len(m) && m[below] {
+				i.sources[pos] = i.MaxIntensity()
 			}
 		}
+
+		if i.occluded[pos] {
+			i.grid[pos] = 0
+		}
+	}
+}
+
+// ClearSources removes the painted sources, leaving the bottom
+// row or the sources from the mask, depending on its mode
+func (i *Inferno) ClearSources() {
+	for pos := range i.sources {
+		i.sources[pos] = 0
+	}
+
+	i.resetSources()
+
+	if i.mask != nil {
+		i.SetMask(i.mask, i.maskMode)
+	}
+}
+
+// resetSources makes the bottom row burn at max intensity
+func (i *Inferno) resetSources() {
+	for j := 0; j < i.width; j++ {
+		i.sources[((i.height-1)*i.width)+j] = i.MaxIntensity()
+	}
+}
+
+// feed moves the fuel towards burning or not, and
+// sets every source in the grid to its intensity
+func (i *Inferno) feed() {
+	if i.burning {
+		i.fuel = math.Min(1, i.fuel+0.01)
+	} else {
+		i.fuel = math.Max(0, i.fuel-0.01)
+	}
+
+	for pos, v := range i.sources {
+		if v > 0 {
+			i.grid[pos] = int(float64(v) * i.fuel)
+		}
+	}
+}
+
+// Render renders the grid into the back buffer
+func (i *Inferno) Render() {
+	for y := 0; y < i.height; y++ {
+		for x := 0; x < i.width; x++ {
+			i.back.SetRGBA(x, y, i.palette.Color(i.grid[(y*i.width)+x]))
+		}
 	}
 }
 
@@ -101,7 +636,7 @@ func (i *Inferno) Spread() {
 	for y := i.height - 1; y > 0; y-- {
 		for x := 0; x < i.width; x++ {
 			src := (y * i.width) + x
-			dst := (src - i.width) + rand.Intn(5) - 2
+			dst := (src - i.width) + rand.Intn(5) - 2 + i.gust()
 
 			if dst < 0 {
 				dst = 0
@@ -111,10 +646,14 @@ func (i *Inferno) Spread() {
 				dst = end
 			}
 
-			i.grid[dst] = i.grid[src] - int8(rand.Intn(6)-1)
+			if i.occluded[dst] {
+				continue
+			}
+
+			i.grid[dst] = i.grid[src] - (rand.Intn(6) - 1)
 
-			if i.grid[dst] > 32 {
-				i.grid[dst] = 32
+			if max := i.MaxIntensity(); i.grid[dst] > max {
+				i.grid[dst] = max
 			}
 
 			if i.grid[dst] < 0 {
@@ -124,7 +663,261 @@ func (i *Inferno) Spread() {
 	}
 }
 
-var cmap = []color.RGBA{
+// gust returns how far the wind blows the fire sideways this time
+func (i *Inferno) gust() int {
+	return int(math.Round(i.wind * rand.Float64() * 3))
+}
+
+// Mask marks cells in the grid, made from an image or some text
+type Mask []bool
+
+func loadImage(fn string) (image.Image, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, _, err := image.Decode(f)
+
+	return m, err
+}
+
+// textImage draws s in white using the face behind text.Atlas7x13
+func textImage(s string) image.Image {
+	face := basicfont.Face7x13
+
+	var (
+		metrics = face.Metrics()
+		w       = font.MeasureString(face, s).Ceil()
+		m       = image.NewRGBA(image.Rect(0, 0, w, metrics.Height.Ceil()))
+	)
+
+	d := &font.Drawer{
+		Dst:  m,
+		Src:  image.White,
+		Face: face,
+		Dot:  fixed.Point26_6{Y: metrics.Ascent},
+	}
+
+	d.DrawString(s)
+
+	return m
+}
+
+// NewMask scales m to cover at most 80% of the grid, keeping its aspect
+// ratio, and sets the cells where m is bright and opaque
+func NewMask(m image.Image, width, height int) Mask {
+	b := m.Bounds()
+
+	rgba := image.NewRGBA(b)
+
+	draw.Draw(rgba, b, m, b.Min, draw.Src)
+
+	var (
+		mask = make(Mask, width*height)
+		s    = math.Min(0.8*float64(width)/float64(b.Dx()), 0.8*float64(height)/float64(b.Dy()))
+		ox   = (float64(width) - float64(b.Dx())*s) / 2
+		oy   = (float64(height) - float64(b.Dy())*s) / 2
+	)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := image.Pt(
+				b.Min.X+int(math.Floor((float64(x)+0.5-ox)/s)),
+				b.Min.Y+int(math.Floor((float64(y)+0.5-oy)/s)),
+			)
+
+			if !p.In(b) {
+				continue
+			}
+
+			c := rgba.RGBAAt(p.X, p.Y)
+
+			// Premultiplied, so this is both bright and opaque
+			mask[(y*width)+x] = int(c.R)+int(c.G)+int(c.B) > 3*127
+		}
+	}
+
+	return mask
+}
+
+// Palette maps the intensity of the fire to colors,
+// from no fire at all to the hottest fire
+type Palette []color.RGBA
+
+// Color returns the color for intensity v
+func (p Palette) Color(v int) color.RGBA {
+	if v < 0 || v >= len(p) {
+		return color.RGBA{0, 0, 0, 255}
+	}
+
+	return p[v]
+}
+
+// LoadPalette loads a palette from a PNG strip, or from a text file
+// with one color per line, either as "R G B" like in GIMP palettes
+// or as hex like "#ff7f00". Lines that are not colors are skipped.
+func LoadPalette(fn string) (Palette, error) {
+	var (
+		p   Palette
+		err error
+	)
+
+	if strings.EqualFold(filepath.Ext(fn), ".png") {
+		p, err = loadPaletteImage(fn)
+	} else {
+		p, err = loadPaletteText(fn)
+	}
+
+	if err == nil && len(p) < 2 {
+		err = errors.New("a palette needs at least two colors")
+	}
+
+	return p, err
+}
+
+func mustLoadPalette(fn string) Palette {
+	p, err := LoadPalette(fn)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fn, err)
+		os.Exit(1)
+	}
+
+	return p
+}
+
+// SavePalette saves p as a GIMP palette, which LoadPalette can load
+func SavePalette(fn string, p Palette) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+
+	fmt.Fprintf(w, "GIMP Palette\nName: %s\n#\n", strings.TrimSuffix(filepath.Base(fn), filepath.Ext(fn)))
+
+	for j, c := range p {
+		fmt.Fprintf(w, "%3d %3d %3d\tIntensity %d\n", c.R, c.G, c.B, j)
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// paletteDir returns the palettes directory next to this file,
+// so that they are found wherever the program is run from
+func paletteDir() string {
+	_, fn, _, ok := runtime.Caller(0)
+	if !ok {
+		return "palettes"
+	}
+
+	return filepath.Join(filepath.Dir(fn), "palettes")
+}
+
+// loadPaletteImage reads the colors along the middle of a
+// strip, from left to right or top to bottom
+func loadPaletteImage(fn string) (Palette, error) {
+	m, err := loadImage(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		p Palette
+		b = m.Bounds()
+	)
+
+	if b.Dx() >= b.Dy() {
+		for x, y := b.Min.X, b.Min.Y+b.Dy()/2; x < b.Max.X; x++ {
+			p = append(p, color.RGBAModel.Convert(m.At(x, y)).(color.RGBA))
+		}
+	} else {
+		for x, y := b.Min.X+b.Dx()/2, b.Min.Y; y < b.Max.Y; y++ {
+			p = append(p, color.RGBAModel.Convert(m.At(x, y)).(color.RGBA))
+		}
+	}
+
+	return p, nil
+}
+
+func loadPaletteText(fn string) (Palette, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var p Palette
+
+	s := bufio.NewScanner(f)
+
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+
+		// Lines starting with # are comments, unless
+		// the whole line is a single hex color
+		if strings.HasPrefix(line, "#") {
+			if c, ok := parseHex(line); ok {
+				p = append(p, c)
+			}
+
+			continue
+		}
+
+		if c, ok := parseRGB(line); ok {
+			p = append(p, c)
+		}
+	}
+
+	return p, s.Err()
+}
+
+// parseHex parses colors like "#ff7f00", and nothing else
+func parseHex(s string) (color.RGBA, bool) {
+	if len(s) != 7 || s[0] != '#' {
+		return color.RGBA{}, false
+	}
+
+	b, err := hex.DecodeString(s[1:])
+	if err != nil {
+		return color.RGBA{}, false
+	}
+
+	return color.RGBA{b[0], b[1], b[2], 0xdc}, true
+}
+
+// parseRGB parses colors like "255 127 0", ignoring anything after them
+func parseRGB(s string) (color.RGBA, bool) {
+	fields := strings.Fields(s)
+
+	if len(fields) < 3 {
+		return color.RGBA{}, false
+	}
+
+	var c [3]uint8
+
+	for j := range c {
+		v, err := strconv.ParseUint(fields[j], 10, 8)
+		if err != nil {
+			return color.RGBA{}, false
+		}
+
+		c[j] = uint8(v)
+	}
+
+	return color.RGBA{c[0], c[1], c[2], 0xdc}, true
+}
+
+// doom is the palette from the PSX version of Doom
+var doom = Palette{
 	{0x07, 0x07, 0x07, 0xdc}, {0x1f, 0x07, 0x07, 0xdc},
 	{0x2f, 0x0f, 0x07, 0xdc}, {0x47, 0x0f, 0x07, 0xdc},
 	{0x57, 0x17, 0x07, 0xdc}, {0x67, 0x1f, 0x07, 0xdc},
@@ -144,11 +937,3 @@ var cmap = []color.RGBA{
 	{0xcf, 0xcf, 0x6f, 0xdc}, {0xdf, 0xdf, 0x9f, 0xdc},
 	{0xef, 0xef, 0xc7, 0xdc}, {0xff, 0xff, 0xff, 0xdc},
 }
-
-func mapColor(v int8) color.RGBA {
-	if v < 0 || int(v) >= len(cmap) {
-		return color.RGBA{0, 0, 0, 255}
-	}
-
-	return cmap[v]
-}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// checkFrame fails if m is not a rendered frame, every pixel is set
+// from the palette and the bottom row is rendered from the source
+func checkFrame(t *testing.T, m *image.RGBA, source color.RGBA) {
+	t.Helper()
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if c := m.RGBAAt(x, y); c == (color.RGBA{}) {
+				t.Fatalf("pixel %d, %d has not been rendered", x, y)
+			}
+		}
+	}
+
+	// The first couple of cells in the bottom row can be
+	// reached by the spread from the end of the row above
+	for x := 2; x < width; x++ {
+		if c := m.RGBAAt(x, height-1); c != source {
+			t.Fatalf("pixel %d in the bottom row is %v, not %v", x, c, source)
+		}
+	}
+}
+
+func TestInfernoStepWhileViewing(t *testing.T) {
+	i := NewInferno(width, height)
+
+	source := i.palette.Color(i.MaxIntensity())
+
+	i.Step()
+
+	i.View(func(m *image.RGBA) {
+		checkFrame(t, m, source)
+	})
+
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for n := 0; n < 200; n++ {
+			i.Step()
+		}
+	}()
+
+	for n := 0; n < 200; n++ {
+		i.View(func(m *image.RGBA) {
+			checkFrame(t, m, source)
+		})
+	}
+
+	wg.Wait()
+}
+
+func TestLoadPaletteText(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "palette.txt")
+
+	data := "GIMP Palette\nName: Test\n# #cafe00 is a comment\n#cafe00 so is this\ndecade\n" +
+		"#070707\n  255 127 0\tOrange\n#FFFFFF\n"
+
+	if err := os.WriteFile(fn, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := loadPaletteText(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Palette{{0x07, 0x07, 0x07, 0xdc}, {255, 127, 0, 0xdc}, {0xff, 0xff, 0xff, 0xdc}}
+
+	if len(p) != len(want) {
+		t.Fatalf("got %d colors %v, want %v", len(p), p, want)
+	}
+
+	for j := range want {
+		if p[j] != want[j] {
+			t.Fatalf("color %d is %v, want %v", j, p[j], want[j])
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
+	"github.com/faiface/pixel/pixelgl"
+)
+
+const (
+	w, h   = 192 * 5, 108 * 5
+	fw, fh = float64(w), float64(h)
+
+	// Half extents of the toroidal box the boids live in
+	bw, bh, bd = 300.0, 200.0, 300.0
+
+	camDist = 700.0
+	focal   = 600.0
+
+	globalScale = 1.28
+)
+
+var (
+	maxSpeed     = 3 * globalScale
+	desireAmount = 1 * globalScale
+	friendRadius = 30 * globalScale
+	crowdRadius  = friendRadius / 1.4
+	coheseRadius = friendRadius / 0.9
+
+	yaw, pitch = 0.4, 0.2
+
+	boids = Boids{}
+
+	gray = color.RGBA{55, 55, 55, 255}
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+
+	spawn(300)
+}
+
+func spawn(n int) {
+	c := randomColor()
+
+	for i := 0; i < n; i++ {
+		p := V3(random(-bw, bw), random(-bh, bh), random(-bd, bd))
+
+		boids = append(boids, newBoid(p, randomDir().Scaled(maxSpeed*rand.Float64()), c))
+	}
+}
+
+func run() {
+	win, err := pixelgl.NewWindow(pixelgl.WindowConfig{
+		Bounds:      pixel.R(0, 0, fw, fh),
+		Undecorated: true,
+		VSync:       true,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	imd := imdraw.New(nil)
+
+	imd.Precision = 7
+
+	imd.SetMatrix(pixel.IM.Moved(win.Bounds().Center()))
+
+	last := time.Now()
+
+	for !win.Closed() {
+		dt := time.Since(last).Seconds()
+		last = time.Now()
+
+		win.SetClosed(win.JustPressed(pixelgl.KeyEscape) || win.JustPressed(pixelgl.KeyQ))
+
+		if win.JustPressed(pixelgl.KeyC) {
+			boids = nil
+		}
+
+		if win.JustPressed(pixelgl.KeySpace) {
+			spawn(50)
+		}
+
+		if win.Pressed(pixelgl.KeyLeft) {
+			yaw -= dt
+		}
+
+		if win.Pressed(pixelgl.KeyRight) {
+			yaw += dt
+		}
+
+		if win.Pressed(pixelgl.KeyUp) {
+			pitch = math.Min(pitch+dt, math.Pi/2)
+		}
+
+		if win.Pressed(pixelgl.KeyDown) {
+			pitch = math.Max(pitch-dt, -math.Pi/2)
+		}
+
+		for _, b := range boids {
+			b.increment()
+
+			if b.think == 0 {
+				b.updateFriends()
+			}
+
+			b.flock()
+			b.updatePosition()
+			b.wrap()
+		}
+
+		imd.Clear()
+
+		drawBox(imd)
+		drawBoids(imd)
+
+		win.Clear(color.RGBA{0, 0, 0, 255})
+		imd.Draw(win)
+		win.Update()
+	}
+}
+
+// view rotates a point in the box into camera space
+func view(p Vec3) Vec3 {
+	sy, cy := math.Sincos(yaw)
+	sp, cp := math.Sincos(pitch)
+
+	x := p.X*cy - p.Z*sy
+	z := p.X*sy + p.Z*cy
+
+	y := p.Y*cp - z*sp
+	z = p.Y*sp + z*cp
+
+	return V3(x, y, z+camDist)
+}
+
+// project performs the perspective divide of a camera space point
+func project(p Vec3) pixel.Vec {
+	return pixel.V(p.X/p.Z*focal, p.Y/p.Z*focal)
+}
+
+func drawBox(imd *imdraw.IMDraw) {
+	imd.Color = gray
+
+	corners := [8]Vec3{}
+
+	for i := range corners {
+		corners[i] = view(V3(
+			bw*float64(i&1*2-1),
+			bh*float64(i>>1&1*2-1),
+			bd*float64(i>>2&1*2-1),
+		))
+	}
+
+	for i := range corners {
+		for _, bit := range []int{1, 2, 4} {
+			if j := i | bit; j != i {
+				imd.Push(project(corners[i]), project(corners[j]))
+				imd.Line(1)
+			}
+		}
+	}
+}
+
+func drawBoids(imd *imdraw.IMDraw) {
+	type projected struct {
+		b *boid
+		v Vec3
+	}
+
+	ps := make([]projected, 0, len(boids))
+
+	for _, b := range boids {
+		if v := view(b.position); v.Z > 1 {
+			ps = append(ps, projected{b, v})
+		}
+	}
+
+	// Painter's algorithm, farthest boids first
+	sort.Slice(ps, func(i, j int) bool {
+		return ps[i].v.Z > ps[j].v.Z
+	})
+
+	var (
+		near = camDist - bd*math.Sqrt(3)
+		far  = camDist + bd*math.Sqrt(3)
+	)
+
+	for _, p := range ps {
+		t := math.Max(0, math.Min(1, scale(p.v.Z, near, far, 1, 0)))
+
+		imd.Color = fade(p.b.color, 0.2+0.8*t)
+		imd.Push(project(p.v))
+		imd.Circle(0.5+4*t, 0)
+
+		tail := view(p.b.position.Sub(p.b.velocity.Scaled(3)))
+
+		if tail.Z > 1 {
+			imd.Push(project(p.v), project(tail))
+			imd.Line(0.5 + 2*t)
+		}
+	}
+}
+
+type Boids []*boid
+
+type boid struct {
+	think    int
+	position Vec3
+	velocity Vec3
+	color    color.RGBA
+	friends  []*boid
+}
+
+func newBoid(p, v Vec3, c color.RGBA) *boid {
+	return &boid{
+		think:    rand.Intn(100),
+		position: p,
+		velocity: v,
+		color:    c,
+	}
+}
+
+func (b *boid) increment() {
+	b.think = (b.think + 1) % 5
+}
+
+func (b *boid) wrap() {
+	b.position.X = wrap(b.position.X, -bw, bw)
+	b.position.Y = wrap(b.position.Y, -bh, bh)
+	b.position.Z = wrap(b.position.Z, -bd, bd)
+}
+
+func (b *boid) updatePosition() {
+	b.position = b.position.Add(b.velocity)
+}
+
+func (b *boid) updateFriends() {
+	var nearby []*boid
+
+	for _, t := range boids {
+		if t != b && offset(b.position, t.position).Len() < friendRadius {
+			nearby = append(nearby, t)
+		}
+	}
+
+	b.friends = nearby
+}
+
+func (b *boid) getAverageDir() Vec3 {
+	sum := Vec3{}
+
+	for _, f := range b.friends {
+		d := offset(b.position, f.position).Len()
+
+		if d > 0 && d < friendRadius {
+			sum = sum.Add(f.velocity.Unit().Scaled(1 / d))
+		}
+	}
+
+	return sum
+}
+
+func (b *boid) getAvoidDir() Vec3 {
+	steer := Vec3{}
+
+	for _, f := range b.friends {
+		o := offset(b.position, f.position)
+		d := o.Len()
+
+		if d > 0 && d < crowdRadius {
+			steer = steer.Add(o.Unit().Scaled(-1 / d))
+		}
+	}
+
+	return steer
+}
+
+func (b *boid) getCohesion() Vec3 {
+	sum := Vec3{}
+
+	count := 0
+
+	for _, other := range b.friends {
+		o := offset(b.position, other.position)
+		d := o.Len()
+
+		if d > 0 && d < coheseRadius {
+			sum = sum.Add(o)
+			count++
+		}
+	}
+
+	if count > 0 {
+		desired := sum.Scaled(1 / float64(count))
+
+		return desired.Unit().Scaled(desireAmount)
+	}
+
+	return Vec3{}
+}
+
+func (b *boid) move(v Vec3) {
+	b.velocity = b.velocity.Add(v)
+}
+
+func (b *boid) limitSpeed(s float64) {
+	b.velocity = b.velocity.Unit().Scaled(s)
+}
+
+func (b *boid) flock() {
+	var (
+		align    = b.getAverageDir().Scaled(1)
+		cohesion = b.getCohesion().Scaled(1)
+		avoidDir = b.getAvoidDir().Scaled(1)
+
+		noise = randomDir().Scaled(0.05)
+	)
+
+	b.move(align)
+	b.move(avoidDir)
+	b.move(noise)
+	b.move(cohesion)
+
+	b.limitSpeed(maxSpeed)
+}
+
+// Vec3 is a 3D vector, modelled on pixel.Vec
+type Vec3 struct {
+	X, Y, Z float64
+}
+
+// V3 returns a new 3D vector with the given coordinates
+func V3(x, y, z float64) Vec3 {
+	return Vec3{x, y, z}
+}
+
+// Add returns the sum of vectors u and v
+func (u Vec3) Add(v Vec3) Vec3 {
+	return Vec3{u.X + v.X, u.Y + v.Y, u.Z + v.Z}
+}
+
+// Sub returns the difference between vectors u and v
+func (u Vec3) Sub(v Vec3) Vec3 {
+	return Vec3{u.X - v.X, u.Y - v.Y, u.Z - v.Z}
+}
+
+// Scaled returns the vector u multiplied by c
+func (u Vec3) Scaled(c float64) Vec3 {
+	return Vec3{u.X * c, u.Y * c, u.Z * c}
+}
+
+// Dot returns the dot product of vectors u and v
+func (u Vec3) Dot(v Vec3) float64 {
+	return u.X*v.X + u.Y*v.Y + u.Z*v.Z
+}
+
+// Len returns the length of the vector u
+func (u Vec3) Len() float64 {
+	return math.Sqrt(u.Dot(u))
+}
+
+// Unit returns a vector of length 1 facing the direction of u
+func (u Vec3) Unit() Vec3 {
+	l := u.Len()
+
+	if l == 0 {
+		return Vec3{1, 0, 0}
+	}
+
+	return u.Scaled(1 / l)
+}
+
+func randomDir() Vec3 {
+	z := random(-1, 1)
+	a := random(0, 2*math.Pi)
+	r := math.Sqrt(1 - z*z)
+
+	return V3(r*math.Cos(a), r*math.Sin(a), z)
+}
+
+// wrap keeps v within [min, max) without losing the fractional part
+func wrap(v, min, max float64) float64 {
+	s := max - min
+
+	v = math.Mod(v-min, s)
+
+	if v < 0 {
+		v += s
+	}
+
+	return v + min
+}
+
+// offset returns the shortest vector from u to v, which may cross
+// the sides of the box since it wraps around
+func offset(u, v Vec3) Vec3 {
+	d := v.Sub(u)
+
+	return V3(nearest(d.X, 2*bw), nearest(d.Y, 2*bh), nearest(d.Z, 2*bd))
+}
+
+// nearest returns d, or d across the wrap if that is closer, given the size s
+func nearest(d, s float64) float64 {
+	switch {
+	case d > s/2:
+		return d - s
+	case d < -s/2:
+		return d + s
+	default:
+		return d
+	}
+}
+
+func fade(c color.RGBA, t float64) color.RGBA {
+	return color.RGBA{
+		uint8(float64(c.R) * t),
+		uint8(float64(c.G) * t),
+		uint8(float64(c.B) * t),
+		255,
+	}
+}
+
+func randomColor() color.RGBA {
+	return color.RGBA{
+		uint8(rand.Intn(200)) + 55,
+		uint8(rand.Intn(200)) + 55,
+		uint8(rand.Intn(200)) + 55,
+		255,
+	}
+}
+
+func random(min, max float64) float64 {
+	return rand.Float64()*(max-min) + min
+}
+
+func scale(unscaledNum, min, max, minAllowed, maxAllowed float64) float64 {
+	return (maxAllowed-minAllowed)*(unscaledNum-min)/(max-min) + minAllowed
+}
+
+func main() {
+	pixelgl.Run(run)
+}
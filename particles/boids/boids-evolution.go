@@ -0,0 +1,555 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
+)
+
+const (
+	w, h   = 192 * 5, 108 * 5
+	fw, fh = float64(w), float64(h)
+
+	globalScale = 0.78
+
+	population       = 150
+	foodCount        = 60
+	generationLength = 1500
+
+	eatRadius = 6.0
+)
+
+var (
+	maxSpeed = 3 * globalScale
+
+	mutationRate   = 0.1
+	mutationAmount = 0.2
+	eliteCount     = 4
+
+	boids     = Boids{}
+	foods     = []pixel.Vec{}
+	obstacles = []*obstacle{}
+
+	generation = 1
+	tick       = 0
+	fast       = false
+	history    = []stats{}
+
+	gray  = color.RGBA{55, 55, 55, 255}
+	green = color.RGBA{80, 200, 80, 255}
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+
+	for i := 0; i < population; i++ {
+		boids = append(boids, newBoid(randomGenome()))
+	}
+
+	for i := 0; i < foodCount; i++ {
+		foods = append(foods, randomPosition())
+	}
+
+	for i := 0; i < 8; i++ {
+		obstacles = append(obstacles, &obstacle{randomPosition(), 20 + rand.Float64()*30})
+	}
+}
+
+func run() {
+	win, err := pixelgl.NewWindow(pixelgl.WindowConfig{
+		Bounds:      pixel.R(0, 0, fw, fh),
+		Undecorated: true,
+		VSync:       true,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	canvas := pixelgl.NewCanvas(win.Bounds())
+
+	imd := imdraw.New(nil)
+
+	txt := text.New(pixel.V(10, fh-20), text.Atlas7x13)
+
+	for !win.Closed() {
+		win.SetClosed(win.JustPressed(pixelgl.KeyEscape) || win.JustPressed(pixelgl.KeyQ))
+
+		if win.JustPressed(pixelgl.KeyF) {
+			fast = !fast
+		}
+
+		if win.JustPressed(pixelgl.KeyN) {
+			evolve()
+		}
+
+		steps := 1
+
+		if fast {
+			steps = 20
+		}
+
+		for i := 0; i < steps; i++ {
+			step()
+		}
+
+		win.Clear(color.RGBA{0, 0, 0, 255})
+
+		imd.Clear()
+
+		for _, o := range obstacles {
+			imd.Color = gray
+			imd.Push(o.position)
+			imd.Circle(o.radius, 0)
+		}
+
+		imd.Color = green
+
+		for _, f := range foods {
+			imd.Push(f)
+			imd.Circle(2, 0)
+		}
+
+		imd.Draw(win)
+
+		drawFrame(canvas)
+
+		canvas.Draw(win, pixel.IM.Moved(win.Bounds().Center()))
+
+		drawHUD(txt)
+
+		txt.Draw(win, pixel.IM)
+
+		win.Update()
+	}
+}
+
+func step() {
+	for _, b := range boids {
+		b.increment()
+		b.wrap()
+
+		if b.think == 0 {
+			b.updateFriends()
+		}
+
+		b.flock()
+
+		b.updatePosition()
+
+		b.eat()
+		b.collide()
+	}
+
+	tick++
+
+	if tick >= generationLength {
+		evolve()
+	}
+}
+
+func drawFrame(canvas *pixelgl.Canvas) {
+	buffer := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for _, b := range boids {
+		b.draw(buffer)
+	}
+
+	canvas.SetPixels(buffer.Pix)
+}
+
+func drawHUD(txt *text.Text) {
+	txt.Clear()
+
+	fmt.Fprintf(txt, "generation %d  tick %d/%d", generation, tick, generationLength)
+
+	if fast {
+		fmt.Fprint(txt, "  (fast)")
+	}
+
+	fmt.Fprintln(txt)
+
+	if len(history) == 0 {
+		return
+	}
+
+	last := history[len(history)-1]
+
+	fmt.Fprintf(txt, "last best %.1f  mean %.1f\n", last.best, last.mean)
+
+	for i, name := range geneNames {
+		fmt.Fprintf(txt, "  %-12s %6.2f\n", name, last.genome[i])
+	}
+}
+
+// stats summarise a finished generation
+type stats struct {
+	best, mean float64
+	genome     genome
+}
+
+// evolve breeds the next generation from the fitness of the current one
+func evolve() {
+	sort.Slice(boids, func(i, j int) bool {
+		return boids[i].fitness() > boids[j].fitness()
+	})
+
+	total := 0.0
+
+	for _, b := range boids {
+		total += b.fitness()
+	}
+
+	history = append(history, stats{
+		best:   boids[0].fitness(),
+		mean:   total / float64(len(boids)),
+		genome: boids[0].genome,
+	})
+
+	next := Boids{}
+
+	for i := 0; i < eliteCount && i < len(boids); i++ {
+		next = append(next, newBoid(boids[i].genome))
+	}
+
+	for len(next) < population {
+		child := crossover(tournament().genome, tournament().genome)
+
+		next = append(next, newBoid(child.mutated()))
+	}
+
+	boids = next
+
+	generation++
+	tick = 0
+}
+
+// tournament picks the fittest of a few random boids
+func tournament() *boid {
+	best := boids[rand.Intn(len(boids))]
+
+	for i := 0; i < 3; i++ {
+		if b := boids[rand.Intn(len(boids))]; b.fitness() > best.fitness() {
+			best = b
+		}
+	}
+
+	return best
+}
+
+const (
+	alignGene = iota
+	cohesionGene
+	separationGene
+	avoidGene
+	hungerGene
+	friendRadiusGene
+	crowdRadiusGene
+	foodRadiusGene
+	geneCount
+)
+
+var (
+	geneNames = [geneCount]string{
+		"align", "cohesion", "separation", "avoid", "hunger",
+		"friendRadius", "crowdRadius", "foodRadius",
+	}
+
+	geneMin = genome{0, 0, 0, 0, 0, 5, 2, 10}
+	geneMax = genome{3, 3, 3, 3, 3, 80, 40, 150}
+)
+
+// genome holds the steering weights and radii of a boid
+type genome [geneCount]float64
+
+func randomGenome() genome {
+	var g genome
+
+	for i := range g {
+		g[i] = geneMin[i] + rand.Float64()*(geneMax[i]-geneMin[i])
+	}
+
+	return g
+}
+
+// crossover picks each gene from either parent
+func crossover(a, b genome) genome {
+	var g genome
+
+	for i := range g {
+		if rand.Float64() < 0.5 {
+			g[i] = a[i]
+		} else {
+			g[i] = b[i]
+		}
+	}
+
+	return g
+}
+
+// mutated nudges some genes by a fraction of their range
+func (g genome) mutated() genome {
+	for i := range g {
+		if rand.Float64() < mutationRate {
+			g[i] += rand.NormFloat64() * mutationAmount * (geneMax[i] - geneMin[i])
+			g[i] = math.Max(geneMin[i], math.Min(geneMax[i], g[i]))
+		}
+	}
+
+	return g
+}
+
+type obstacle struct {
+	position pixel.Vec
+	radius   float64
+}
+
+type Boids []*boid
+
+type boid struct {
+	think      int
+	position   pixel.Vec
+	velocity   pixel.Vec
+	genome     genome
+	eaten      int
+	collisions int
+	friends    []*boid
+}
+
+func newBoid(g genome) *boid {
+	angle := rand.Float64() * 2 * math.Pi
+
+	return &boid{
+		think:    rand.Intn(100),
+		position: randomPosition(),
+		velocity: pixel.V(math.Cos(angle), math.Sin(angle)).Scaled(maxSpeed),
+		genome:   g,
+	}
+}
+
+// fitness rewards food collected and penalises hitting obstacles
+func (b *boid) fitness() float64 {
+	return float64(b.eaten) - 0.1*float64(b.collisions)
+}
+
+func (b *boid) increment() {
+	b.think = (b.think + 1) % 5
+}
+
+func (b *boid) wrap() {
+	b.position.X = wrap(b.position.X, fw)
+	b.position.Y = wrap(b.position.Y, fh)
+}
+
+func (b *boid) updatePosition() {
+	b.position = b.position.Add(b.velocity)
+}
+
+func (b *boid) updateFriends() {
+	var (
+		nearby []*boid
+		r      = b.genome[friendRadiusGene]
+	)
+
+	for _, t := range boids {
+		if t != b {
+			if math.Abs(t.position.X-b.position.X) < r &&
+				math.Abs(t.position.Y-b.position.Y) < r {
+				nearby = append(nearby, t)
+			}
+		}
+	}
+
+	b.friends = nearby
+}
+
+func (b *boid) getAverageDir() pixel.Vec {
+	sum := pixel.V(0, 0)
+
+	for _, f := range b.friends {
+		d := dist(b.position, f.position)
+
+		if d > 0 && d < b.genome[friendRadiusGene] {
+			sum = sum.Add(div(f.velocity.Unit(), d))
+		}
+	}
+
+	return sum
+}
+
+func (b *boid) getAvoidDir() pixel.Vec {
+	steer := pixel.V(0, 0)
+
+	for _, f := range b.friends {
+		d := dist(b.position, f.position)
+
+		if d > 0 && d < b.genome[crowdRadiusGene] {
+			diff := div(b.position.Sub(f.position).Unit(), d)
+			steer = steer.Add(diff)
+		}
+	}
+
+	return steer
+}
+
+func (b *boid) getAvoidObjects() pixel.Vec {
+	steer := pixel.V(0, 0)
+
+	for _, o := range obstacles {
+		diff := b.position.Sub(o.position)
+
+		if d := diff.Len() - o.radius; d < 20 {
+			steer = steer.Add(diff.Unit().Scaled((20 - d) / 20))
+		}
+	}
+
+	return steer
+}
+
+func (b *boid) getCohesion() pixel.Vec {
+	sum := pixel.V(0, 0)
+
+	count := 0
+
+	for _, other := range b.friends {
+		d := dist(b.position, other.position)
+
+		if d > 0 && d < b.genome[friendRadiusGene] {
+			sum = sum.Add(other.position)
+			count++
+		}
+	}
+
+	if count > 0 {
+		return div(sum, float64(count)).Sub(b.position).Unit()
+	}
+
+	return pixel.V(0, 0)
+}
+
+func (b *boid) getFood() pixel.Vec {
+	var (
+		best  = b.genome[foodRadiusGene]
+		steer = pixel.V(0, 0)
+	)
+
+	for _, f := range foods {
+		if d := f.Sub(b.position).Len(); d < best {
+			best, steer = d, f.Sub(b.position).Unit()
+		}
+	}
+
+	return steer
+}
+
+func (b *boid) move(v pixel.Vec) {
+	b.velocity = b.velocity.Add(v)
+}
+
+func (b *boid) limitSpeed(s float64) {
+	b.velocity = b.velocity.Unit().Scaled(s)
+}
+
+func (b *boid) flock() {
+	var (
+		g = b.genome
+
+		align        = b.getAverageDir().Scaled(g[alignGene])
+		cohesion     = b.getCohesion().Scaled(g[cohesionGene])
+		avoidDir     = b.getAvoidDir().Scaled(g[separationGene])
+		avoidObjects = b.getAvoidObjects().Scaled(g[avoidGene])
+		food         = b.getFood().Scaled(g[hungerGene])
+
+		noise = pixel.V(rand.Float64()*2-1, rand.Float64()*2-1).Scaled(0.05)
+	)
+
+	b.move(align)
+	b.move(avoidDir)
+	b.move(avoidObjects)
+	b.move(noise)
+	b.move(cohesion)
+	b.move(food)
+
+	b.limitSpeed(maxSpeed)
+}
+
+func (b *boid) eat() {
+	for i, f := range foods {
+		if f.Sub(b.position).Len() < eatRadius {
+			b.eaten++
+
+			foods[i] = randomPosition()
+		}
+	}
+}
+
+// collide counts and resolves overlaps with obstacles
+func (b *boid) collide() {
+	for _, o := range obstacles {
+		diff := b.position.Sub(o.position)
+
+		if diff.Len() < o.radius {
+			b.collisions++
+
+			b.position = o.position.Add(diff.Unit().Scaled(o.radius))
+		}
+	}
+}
+
+func (b *boid) color() color.RGBA {
+	g := b.genome
+
+	return color.RGBA{
+		uint8(55 + 200*g[alignGene]/geneMax[alignGene]),
+		uint8(55 + 200*g[hungerGene]/geneMax[hungerGene]),
+		uint8(55 + 200*g[cohesionGene]/geneMax[cohesionGene]),
+		255,
+	}
+}
+
+func (b *boid) draw(m *image.RGBA) {
+	x, y := int(b.position.X), int(b.position.Y)
+
+	r := image.Rect(x-1, y-1, x+1, y+1)
+
+	draw.Draw(m, r, &image.Uniform{b.color()}, image.ZP, draw.Src)
+}
+
+func randomPosition() pixel.Vec {
+	return pixel.V(rand.Float64()*fw, rand.Float64()*fh)
+}
+
+// wrap returns v wrapped into [0, max) keeping the fractional part
+func wrap(v, max float64) float64 {
+	v = math.Mod(v, max)
+
+	if v < 0 {
+		v += max
+	}
+
+	return v
+}
+
+func dist(a, b pixel.Vec) float64 {
+	return math.Abs(a.X-b.X) + math.Abs(a.Y-b.Y)
+}
+
+func div(v pixel.Vec, d float64) pixel.Vec {
+	v.X /= d
+	v.Y /= d
+
+	return v
+}
+
+func main() {
+	pixelgl.Run(run)
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
+	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
+)
+
+const (
+	w, h   = 192 * 5, 108 * 5
+	fw, fh = float64(w), float64(h)
+
+	globalScale = 0.78
+)
+
+var (
+	maxSpeed     = 3 * globalScale
+	minSpeed     = 0.5 * globalScale
+	desireAmount = 1 * globalScale
+	friendRadius = 30 * globalScale
+	crowdRadius  = friendRadius / 1.4
+	coheseRadius = friendRadius / 0.9
+	smellRadius  = 120 * globalScale
+
+	// Energy drained every frame, plus a part proportional to speed²
+	baseDrain  = 0.02
+	speedDrain = 0.01
+
+	biteSize        = 0.5
+	startEnergy     = 40.0
+	reproduceEnergy = 80.0
+	maxEnergy       = 100.0
+	patchRegrowth   = 0.02
+	patchMaxAmount  = 200.0
+	initialPatches  = 6
+	initialBoids    = 150
+	maxPopulation   = 2000
+
+	boids   = Boids{}
+	patches = Patches{}
+
+	green = color.RGBA{40, 140, 40, 255}
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+
+	setup()
+}
+
+func setup() {
+	for i := 0; i < initialPatches; i++ {
+		patches = append(patches, newPatch(randomPosition(), 20+rand.Float64()*20))
+	}
+
+	for i := 0; i < initialBoids; i++ {
+		boids = append(boids, randomColorBoidAt(randomPosition()))
+	}
+}
+
+func run() {
+	win, err := pixelgl.NewWindow(pixelgl.WindowConfig{
+		Bounds:      pixel.R(0, 0, fw, fh),
+		Undecorated: true,
+		VSync:       true,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	canvas := pixelgl.NewCanvas(win.Bounds())
+
+	imd := imdraw.New(nil)
+
+	txt := text.New(pixel.V(10, fh-20), text.Atlas7x13)
+
+	for !win.Closed() {
+		win.SetClosed(win.JustPressed(pixelgl.KeyEscape) || win.JustPressed(pixelgl.KeyQ))
+
+		if win.JustPressed(pixelgl.KeyC) {
+			boids, patches = nil, nil
+			setup()
+		}
+
+		pos := win.MousePosition()
+
+		if win.Pressed(pixelgl.MouseButtonLeft) {
+			boids = append(boids, randomColorBoidAt(pos))
+		}
+
+		if win.JustPressed(pixelgl.MouseButtonRight) {
+			patches = append(patches, newPatch(pos, 30))
+		}
+
+		for _, p := range patches {
+			p.regrow()
+		}
+
+		win.Clear(color.RGBA{0, 0, 0, 255})
+
+		imd.Clear()
+
+		for _, p := range patches {
+			p.draw(imd)
+		}
+
+		imd.Draw(win)
+
+		drawFrame(canvas)
+
+		canvas.Draw(win, pixel.IM.Moved(win.Bounds().Center()))
+
+		txt.Clear()
+
+		fmt.Fprintf(txt, "boids %d\nfood  %.0f", len(boids), patches.total())
+
+		txt.Draw(win, pixel.IM)
+
+		win.Update()
+	}
+}
+
+func drawFrame(canvas *pixelgl.Canvas) {
+	buffer := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	alive := Boids{}
+
+	for _, b := range boids {
+		b.increment()
+		b.wrap()
+
+		if b.think == 0 {
+			b.updateFriends()
+		}
+
+		b.flock()
+
+		b.updatePosition()
+
+		b.metabolise()
+
+		b.draw(buffer)
+
+		if b.energy > 0 {
+			alive = append(alive, b)
+		}
+
+		if b.energy > reproduceEnergy && len(alive) < maxPopulation {
+			alive = append(alive, b.reproduce())
+		}
+	}
+
+	boids = alive
+
+	canvas.SetPixels(buffer.Pix)
+}
+
+type Patches []*patch
+
+// patch is a consumable food source that slowly regrows
+type patch struct {
+	position pixel.Vec
+	radius   float64
+	amount   float64
+}
+
+func newPatch(p pixel.Vec, r float64) *patch {
+	return &patch{position: p, radius: r, amount: patchMaxAmount / 2}
+}
+
+func (p *patch) regrow() {
+	p.amount = math.Min(patchMaxAmount, p.amount+patchRegrowth*p.radius)
+}
+
+// bite takes up to n food from the patch and returns how much was taken
+func (p *patch) bite(n float64) float64 {
+	n = math.Min(n, p.amount)
+
+	p.amount -= n
+
+	return n
+}
+
+func (p *patch) draw(imd *imdraw.IMDraw) {
+	t := p.amount / patchMaxAmount
+
+	imd.Color = color.RGBA{uint8(float64(green.R) * t), uint8(float64(green.G) * t), uint8(float64(green.B) * t), 255}
+	imd.Push(p.position)
+	imd.Circle(p.radius, 0)
+}
+
+func (ps Patches) total() float64 {
+	sum := 0.0
+
+	for _, p := range ps {
+		sum += p.amount
+	}
+
+	return sum
+}
+
+type Boids []*boid
+
+type boid struct {
+	size     int
+	think    int
+	energy   float64
+	position pixel.Vec
+	velocity pixel.Vec
+	color    color.RGBA
+	friends  []*boid
+}
+
+func newBoid(x, y, angle, speed float64, c color.RGBA) *boid {
+	angleInRadians := angle * math.Pi / 180
+
+	return &boid{
+		size:     rand.Intn(2) + 1,
+		think:    rand.Intn(100),
+		energy:   startEnergy,
+		position: pixel.Vec{X: x, Y: y},
+		velocity: pixel.Vec{
+			X: speed * math.Cos(angleInRadians),
+			Y: -speed * math.Sin(angleInRadians),
+		},
+		color: c,
+	}
+}
+
+func randomColorBoidAt(p pixel.Vec) *boid {
+	angle := rand.Float64() * 360
+	speed := maxSpeed * rand.Float64()
+
+	return newBoid(p.X, p.Y, angle, speed, randomColor())
+}
+
+func (b *boid) increment() {
+	b.think = (b.think + 1) % 5
+}
+
+func (b *boid) wrap() {
+	b.position.X = wrap(b.position.X, fw)
+	b.position.Y = wrap(b.position.Y, fh)
+}
+
+func (b *boid) updatePosition() {
+	b.position = b.position.Add(b.velocity)
+}
+
+// metabolise drains energy with speed and refills it by eating
+func (b *boid) metabolise() {
+	speed := b.velocity.Len()
+
+	b.energy -= baseDrain + speedDrain*speed*speed
+
+	for _, p := range patches {
+		if b.position.Sub(p.position).Len() < p.radius {
+			b.energy += p.bite(biteSize)
+		}
+	}
+
+	b.energy = math.Min(b.energy, maxEnergy)
+}
+
+// reproduce splits the energy of the boid with a new child
+func (b *boid) reproduce() *boid {
+	b.energy /= 2
+
+	child := newBoid(b.position.X, b.position.Y, rand.Float64()*360, b.velocity.Len(), mutateColor(b.color))
+
+	child.energy = b.energy
+
+	return child
+}
+
+// hunger is 0 when full and 1 when starving
+func (b *boid) hunger() float64 {
+	return 1 - math.Max(0, math.Min(1, b.energy/reproduceEnergy))
+}
+
+func (b *boid) updateFriends() {
+	var nearby []*boid
+
+	for _, t := range boids {
+		if t != b {
+			if math.Abs(t.position.X-b.position.X) < friendRadius &&
+				math.Abs(t.position.Y-b.position.Y) < friendRadius {
+				nearby = append(nearby, t)
+			}
+		}
+	}
+
+	b.friends = nearby
+}
+
+func (b *boid) getAverageDir() pixel.Vec {
+	sum := pixel.V(0, 0)
+
+	for _, f := range b.friends {
+		d := dist(b.position, f.position)
+
+		if d > 0 && d < friendRadius {
+			sum = sum.Add(div(f.velocity.Unit(), d))
+		}
+	}
+
+	return sum
+}
+
+func (b *boid) getAvoidDir() pixel.Vec {
+	steer := pixel.V(0, 0)
+
+	for _, f := range b.friends {
+		d := dist(b.position, f.position)
+
+		if d > 0 && d < crowdRadius {
+			diff := div(b.position.Sub(f.position).Unit(), d)
+			steer = steer.Add(diff)
+		}
+	}
+
+	return steer
+}
+
+func (b *boid) getCohesion() pixel.Vec {
+	sum := pixel.V(0, 0)
+
+	count := 0
+
+	for _, other := range b.friends {
+		d := dist(b.position, other.position)
+
+		if d > 0 && d < coheseRadius {
+			sum = sum.Add(other.position)
+			count++
+		}
+	}
+
+	if count > 0 {
+		desired := div(sum, float64(count)).Sub(b.position)
+
+		return desired.Unit().Scaled(desireAmount)
+	}
+
+	return pixel.V(0, 0)
+}
+
+// getFood steers towards the closest patch with food left,
+// ignoring patches the boid can not smell
+func (b *boid) getFood() pixel.Vec {
+	var (
+		best  = smellRadius
+		steer = pixel.V(0, 0)
+	)
+
+	for _, p := range patches {
+		if p.amount < biteSize {
+			continue
+		}
+
+		if d := p.position.Sub(b.position).Len(); d < best {
+			best, steer = d, p.position.Sub(b.position).Unit()
+		}
+	}
+
+	return steer
+}
+
+func (b *boid) move(v pixel.Vec) {
+	b.velocity = b.velocity.Add(v)
+}
+
+// limitSpeed keeps the speed between min and max
+func (b *boid) limitSpeed(min, max float64) {
+	s := math.Max(min, math.Min(max, b.velocity.Len()))
+
+	b.velocity = b.velocity.Unit().Scaled(s)
+}
+
+func (b *boid) flock() {
+	var (
+		hunger = b.hunger()
+
+		align    = b.getAverageDir().Scaled(1 - hunger)
+		cohesion = b.getCohesion().Scaled(1 - hunger)
+		avoidDir = b.getAvoidDir().Scaled(1)
+		food     = b.getFood().Scaled(hunger * 2)
+
+		noise = pixel.V(rand.Float64()*2-1, rand.Float64()*2-1).Scaled(0.05)
+	)
+
+	b.move(align)
+	b.move(avoidDir)
+	b.move(noise)
+	b.move(cohesion)
+	b.move(food)
+
+	// Drag, so that boids with nothing to steer for slow down and save energy
+	b.velocity = b.velocity.Scaled(0.98)
+
+	b.limitSpeed(minSpeed, maxSpeed)
+}
+
+func (b *boid) draw(m *image.RGBA) {
+	x, y := int(b.position.X), int(b.position.Y)
+
+	r := image.Rect(x-b.size, y-b.size, x+b.size, y+b.size)
+
+	draw.Draw(m, r, &image.Uniform{b.color}, image.ZP, draw.Src)
+}
+
+func mutateColor(c color.RGBA) color.RGBA {
+	m := func(v uint8) uint8 {
+		return uint8(math.Max(55, math.Min(255, float64(v)+rand.NormFloat64()*10)))
+	}
+
+	return color.RGBA{m(c.R), m(c.G), m(c.B), 255}
+}
+
+func randomColor() color.RGBA {
+	return color.RGBA{
+		uint8(rand.Intn(200)) + 55,
+		uint8(rand.Intn(200)) + 55,
+		uint8(rand.Intn(200)) + 55,
+		255,
+	}
+}
+
+func randomPosition() pixel.Vec {
+	return pixel.V(rand.Float64()*fw, rand.Float64()*fh)
+}
+
+// wrap returns v wrapped into [0, max) keeping the fractional part
+func wrap(v, max float64) float64 {
+	v = math.Mod(v, max)
+
+	if v < 0 {
+		v += max
+	}
+
+	return v
+}
+
+func dist(a, b pixel.Vec) float64 {
+	return math.Abs(a.X-b.X) + math.Abs(a.Y-b.Y)
+}
+
+func div(v pixel.Vec, d float64) pixel.Vec {
+	v.X /= d
+	v.Y /= d
+
+	return v
+}
+
+func main() {
+	pixelgl.Run(run)
+}
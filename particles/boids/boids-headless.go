@@ -0,0 +1,631 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+
+	"github.com/faiface/pixel"
+)
+
+const (
+	w, h   = 192 * 5, 108 * 5
+	fw, fh = float64(w), float64(h)
+
+	globalScale = 0.78
+)
+
+var (
+	maxSpeed     = 3 * globalScale
+	desireAmount = 1 * globalScale
+	friendRadius = 30 * globalScale
+	crowdRadius  = friendRadius / 1.4
+	coheseRadius = friendRadius / 0.9
+
+	boids = Boids{}
+)
+
+func main() {
+	var (
+		steps   = flag.Int("steps", 1000, "number of simulation steps")
+		count   = flag.Int("boids", 200, "number of boids")
+		seed    = flag.Int64("seed", 1, "random seed")
+		format  = flag.String("format", "csv", "output format, csv or jsonl")
+		out     = flag.String("out", "-", "per boid output file, - for stdout")
+		metrics = flag.String("metrics", "", "per frame metrics output file")
+		record  = flag.String("record", "", "record the frames to this GIF file")
+		frames  = flag.Int("frames", 300, "number of frames to record")
+	)
+
+	flag.Parse()
+
+	// The two streams would be interleaved line by line
+	if *out == "-" && *metrics == "-" {
+		fatal(errors.New("-out and -metrics can not both be written to stdout"))
+	}
+
+	rand.Seed(*seed)
+
+	for i := 0; i < *count; i++ {
+		boids = append(boids, randomBoid(i))
+	}
+
+	be, err := newExporter(*format, *out)
+	if err != nil {
+		fatal(err)
+	}
+
+	var (
+		me  exporter
+		rec *recorder
+	)
+
+	if *record != "" {
+		rec = newRecorder(*record, *frames)
+	}
+
+	if *metrics != "" {
+		if me, err = newExporter(*format, *metrics); err != nil {
+			fatal(err)
+		}
+	}
+
+	for frame := 0; frame < *steps; frame++ {
+		step()
+
+		for _, b := range boids {
+			be.boid(frame, b)
+		}
+
+		if me != nil {
+			me.metrics(frame, measure())
+		}
+
+		if rec != nil && !rec.done() {
+			rec.capture(render())
+		}
+	}
+
+	if err := be.Close(); err != nil {
+		fatal(err)
+	}
+
+	if me != nil {
+		if err := me.Close(); err != nil {
+			fatal(err)
+		}
+	}
+
+	if rec != nil {
+		if err := rec.save(); err != nil {
+			fatal(err)
+		}
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func render() *image.RGBA {
+	buffer := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	for _, b := range boids {
+		b.draw(buffer)
+	}
+
+	return buffer
+}
+
+func step() {
+	for _, b := range boids {
+		b.increment()
+
+		if b.think == 0 {
+			b.updateFriends()
+		}
+
+		b.flock()
+
+		b.updatePosition()
+		b.wrap()
+	}
+}
+
+// Metrics are aggregate measurements of the flock in a single frame
+type Metrics struct {
+	// Polarisation is the length of the mean heading, 1 when all boids
+	// fly in the same direction and close to 0 when they are disordered
+	Polarisation float64 `json:"polarisation"`
+
+	// Groups is the number of connected groups of boids,
+	// where boids within friendRadius of each other are connected
+	Groups int `json:"groups"`
+
+	// NearestNeighbour is the mean distance to the closest other boid
+	NearestNeighbour float64 `json:"nearest_neighbour"`
+}
+
+func measure() Metrics {
+	var (
+		n       = len(boids)
+		heading = pixel.V(0, 0)
+		nearest = 0.0
+		parent  = make([]int, n)
+	)
+
+	if n == 0 {
+		return Metrics{}
+	}
+
+	for i := range parent {
+		parent[i] = i
+	}
+
+	var find func(i int) int
+
+	find = func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+
+		return i
+	}
+
+	for i, b := range boids {
+		heading = heading.Add(b.velocity.Unit())
+
+		closest := math.Inf(1)
+
+		for j, o := range boids {
+			if i == j {
+				continue
+			}
+
+			d := offset(b.position, o.position).Len()
+
+			if d < closest {
+				closest = d
+			}
+
+			if d < friendRadius {
+				parent[find(i)] = find(j)
+			}
+		}
+
+		if n > 1 {
+			nearest += closest
+		}
+	}
+
+	groups := 0
+
+	for i := range parent {
+		if find(i) == i {
+			groups++
+		}
+	}
+
+	return Metrics{
+		Polarisation:     heading.Len() / float64(n),
+		Groups:           groups,
+		NearestNeighbour: nearest / float64(n),
+	}
+}
+
+// exporter streams boid state and metrics to a file
+type exporter interface {
+	boid(frame int, b *boid)
+	metrics(frame int, m Metrics)
+	Close() error
+}
+
+// nopCloser keeps stdout open when an exporter writing to it is closed
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func newExporter(format, name string) (exporter, error) {
+	var wc io.WriteCloser = nopCloser{os.Stdout}
+
+	if name != "-" {
+		f, err := os.Create(name)
+		if err != nil {
+			return nil, err
+		}
+
+		wc = f
+	}
+
+	bw := bufio.NewWriter(wc)
+
+	switch format {
+	case "csv":
+		return &csvExporter{w: csv.NewWriter(bw), bw: bw, c: wc}, nil
+	case "jsonl":
+		return &jsonlExporter{enc: json.NewEncoder(bw), bw: bw, c: wc}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+type csvExporter struct {
+	w      *csv.Writer
+	bw     *bufio.Writer
+	c      io.Closer
+	header bool
+}
+
+func (e *csvExporter) write(header []string, record ...float64) {
+	if !e.header {
+		e.w.Write(header)
+		e.header = true
+	}
+
+	row := make([]string, len(record))
+
+	for i, v := range record {
+		row[i] = strconv.FormatFloat(v, 'f', -1, 64)
+	}
+
+	e.w.Write(row)
+}
+
+func (e *csvExporter) boid(frame int, b *boid) {
+	e.write([]string{"frame", "id", "x", "y", "vx", "vy"},
+		float64(frame), float64(b.id),
+		b.position.X, b.position.Y,
+		b.velocity.X, b.velocity.Y,
+	)
+}
+
+func (e *csvExporter) metrics(frame int, m Metrics) {
+	e.write([]string{"frame", "polarisation", "groups", "nearest_neighbour"},
+		float64(frame), m.Polarisation, float64(m.Groups), m.NearestNeighbour,
+	)
+}
+
+func (e *csvExporter) Close() error {
+	e.w.Flush()
+
+	if err := e.w.Error(); err != nil {
+		return err
+	}
+
+	if err := e.bw.Flush(); err != nil {
+		return err
+	}
+
+	return e.c.Close()
+}
+
+type jsonlExporter struct {
+	enc *json.Encoder
+	bw  *bufio.Writer
+	c   io.Closer
+	err error
+}
+
+func (e *jsonlExporter) encode(v interface{}) {
+	if e.err == nil {
+		e.err = e.enc.Encode(v)
+	}
+}
+
+func (e *jsonlExporter) boid(frame int, b *boid) {
+	e.encode(struct {
+		Frame int     `json:"frame"`
+		ID    int     `json:"id"`
+		X     float64 `json:"x"`
+		Y     float64 `json:"y"`
+		VX    float64 `json:"vx"`
+		VY    float64 `json:"vy"`
+	}{frame, b.id, b.position.X, b.position.Y, b.velocity.X, b.velocity.Y})
+}
+
+func (e *jsonlExporter) metrics(frame int, m Metrics) {
+	e.encode(struct {
+		Frame int `json:"frame"`
+		Metrics
+	}{frame, m})
+}
+
+func (e *jsonlExporter) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+
+	if err := e.bw.Flush(); err != nil {
+		return err
+	}
+
+	return e.c.Close()
+}
+
+type Boids []*boid
+
+type boid struct {
+	id       int
+	think    int
+	position pixel.Vec
+	velocity pixel.Vec
+	color    color.RGBA
+	friends  []*boid
+}
+
+func newBoid(id int, x, y, angle, speed float64) *boid {
+	angleInRadians := angle * math.Pi / 180
+
+	return &boid{
+		id:       id,
+		think:    rand.Intn(100),
+		color:    randomColor(),
+		position: pixel.Vec{X: x, Y: y},
+		velocity: pixel.Vec{
+			X: speed * math.Cos(angleInRadians),
+			Y: -speed * math.Sin(angleInRadians),
+		},
+	}
+}
+
+func randomBoid(id int) *boid {
+	angle := rand.Float64() * 360
+	speed := maxSpeed * rand.Float64()
+
+	return newBoid(id, rand.Float64()*fw, rand.Float64()*fh, angle, speed)
+}
+
+func (b *boid) increment() {
+	b.think = (b.think + 1) % 5
+}
+
+func (b *boid) wrap() {
+	b.position.X = wrap(b.position.X, fw)
+	b.position.Y = wrap(b.position.Y, fh)
+}
+
+func (b *boid) updatePosition() {
+	b.position = b.position.Add(b.velocity)
+}
+
+func (b *boid) updateFriends() {
+	var nearby []*boid
+
+	for _, t := range boids {
+		if t != b {
+			if math.Abs(t.position.X-b.position.X) < friendRadius &&
+				math.Abs(t.position.Y-b.position.Y) < friendRadius {
+				nearby = append(nearby, t)
+			}
+		}
+	}
+
+	b.friends = nearby
+}
+
+func (b *boid) getAverageDir() pixel.Vec {
+	sum := pixel.V(0, 0)
+
+	for _, f := range b.friends {
+		d := dist(b.position, f.position)
+
+		if d > 0 && d < friendRadius {
+			sum = sum.Add(div(f.velocity.Unit(), d))
+		}
+	}
+
+	return sum
+}
+
+func (b *boid) getAvoidDir() pixel.Vec {
+	steer := pixel.V(0, 0)
+
+	for _, f := range b.friends {
+		d := dist(b.position, f.position)
+
+		if d > 0 && d < crowdRadius {
+			diff := div(b.position.Sub(f.position).Unit(), d)
+			steer = steer.Add(diff)
+		}
+	}
+
+	return steer
+}
+
+func (b *boid) getCohesion() pixel.Vec {
+	sum := pixel.V(0, 0)
+
+	count := 0
+
+	for _, other := range b.friends {
+		d := dist(b.position, other.position)
+
+		if d > 0 && d < coheseRadius {
+			sum = sum.Add(other.position)
+			count++
+		}
+	}
+
+	if count > 0 {
+		desired := div(sum, float64(count)).Sub(b.position)
+
+		return desired.Unit().Scaled(desireAmount)
+	}
+
+	return pixel.V(0, 0)
+}
+
+func (b *boid) move(v pixel.Vec) {
+	b.velocity = b.velocity.Add(v)
+}
+
+func (b *boid) limitSpeed(s float64) {
+	b.velocity = b.velocity.Unit().Scaled(s)
+}
+
+func (b *boid) flock() {
+	var (
+		align    = b.getAverageDir().Scaled(1)
+		cohesion = b.getCohesion().Scaled(1)
+		avoidDir = b.getAvoidDir().Scaled(1)
+
+		noise = pixel.V(rand.Float64()*2-1, rand.Float64()*2-1).Scaled(0.05)
+	)
+
+	b.move(align)
+	b.move(avoidDir)
+	b.move(noise)
+	b.move(cohesion)
+
+	b.limitSpeed(maxSpeed)
+}
+
+// wrap returns v wrapped into [0, max) keeping the fractional part
+func wrap(v, max float64) float64 {
+	v = math.Mod(v, max)
+
+	if v < 0 {
+		v += max
+	}
+
+	return v
+}
+
+// offset returns the shortest vector from a to b,
+// which may cross the edges of the window since they wrap
+func offset(a, b pixel.Vec) pixel.Vec {
+	d := b.Sub(a)
+
+	return pixel.V(nearest(d.X, fw), nearest(d.Y, fh))
+}
+
+// nearest returns d, or d across the wrap if that is closer, given the size s
+func nearest(d, s float64) float64 {
+	switch {
+	case d > s/2:
+		return d - s
+	case d < -s/2:
+		return d + s
+	default:
+		return d
+	}
+}
+
+func (b *boid) draw(m *image.RGBA) {
+	x, y := int(b.position.X), int(b.position.Y)
+
+	r := image.Rect(x-1, y-1, x+1, y+1)
+
+	draw.Draw(m, r, &image.Uniform{b.color}, image.ZP, draw.Src)
+}
+
+// recorder captures frames into an animated GIF
+type recorder struct {
+	path   string
+	frames int
+	anim   gif.GIF
+}
+
+func newRecorder(path string, frames int) *recorder {
+	return &recorder{path: path, frames: frames}
+}
+
+// capture quantises m using a palette built from the boid colors,
+// flipping it vertically since the canvas has its origin at the bottom
+func (r *recorder) capture(m *image.RGBA) {
+	var (
+		b = m.Bounds()
+		p = image.NewPaletted(b, boidPalette())
+		f = image.NewRGBA(b)
+	)
+
+	for y := 0; y < b.Dy(); y++ {
+		copy(f.Pix[y*f.Stride:(y+1)*f.Stride], m.Pix[(b.Dy()-1-y)*m.Stride:])
+	}
+
+	draw.Draw(p, b, f, b.Min, draw.Src)
+
+	r.anim.Image = append(r.anim.Image, p)
+	r.anim.Delay = append(r.anim.Delay, 2)
+}
+
+// done reports if the requested number of frames have been captured
+func (r *recorder) done() bool {
+	return r.frames > 0 && len(r.anim.Image) >= r.frames
+}
+
+func (r *recorder) save() error {
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+
+	if err := gif.EncodeAll(f, &r.anim); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// boidPalette returns black followed by the distinct boid colors,
+// any colors beyond the 256 that fit are mapped to their closest match
+func boidPalette() color.Palette {
+	var (
+		p    = color.Palette{color.RGBA{0, 0, 0, 255}}
+		seen = map[color.RGBA]bool{}
+	)
+
+	for _, b := range boids {
+		if len(p) == 256 {
+			break
+		}
+
+		if c := b.color; !seen[c] {
+			seen[c] = true
+
+			p = append(p, c)
+		}
+	}
+
+	return p
+}
+
+func randomColor() color.RGBA {
+	return color.RGBA{
+		uint8(rand.Intn(200)) + 55,
+		uint8(rand.Intn(200)) + 55,
+		uint8(rand.Intn(200)) + 55,
+		255,
+	}
+}
+
+func dist(a, b pixel.Vec) float64 {
+	return math.Abs(a.X-b.X) + math.Abs(a.Y-b.Y)
+}
+
+func div(v pixel.Vec, d float64) pixel.Vec {
+	v.X /= d
+	v.Y /= d
+
+	return v
+}
@@ -1,16 +1,21 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
+	"image/gif"
 	"math"
 	"math/rand"
+	"os"
 	"time"
 
 	"github.com/faiface/pixel"
+	"github.com/faiface/pixel/imdraw"
 	"github.com/faiface/pixel/pixelgl"
+	"github.com/faiface/pixel/text"
 )
 
 const (
@@ -27,13 +32,75 @@ var (
 	crowdRadius  = friendRadius / 1.4
 	avoidRadius  = 16 * globalScale
 	coheseRadius = friendRadius / 0.9
+	lookAhead    = 40 * globalScale
+	edgeMargin   = 60 * globalScale
 
 	boids  = Boids{}
 	avoids = Avoids{}
+	walls  = Avoids{}
+
+	// obstacles are the avoids and, in wall mode, the walls
+	obstacles = Avoids{}
+
+	edges     = wallEdges
+	edgeNames = [...]string{"walls", "wrap", "bounce", "steer"}
 
 	gray = color.RGBA{55, 55, 55, 255}
+	blue = color.RGBA{55, 55, 155, 255}
 
 	currentColor color.RGBA
+
+	tool    = segmentTool
+	drawing []pixel.Vec
+
+	showHUD  = true
+	selected = 0
+
+	renderer   = squareRenderer
+	showTrails = false
+
+	rec *recorder
+
+	params = []param{
+		{"maxSpeed", &maxSpeed, 0.1},
+		{"desireAmount", &desireAmount, 0.1},
+		{"friendRadius", &friendRadius, 1},
+		{"crowdRadius", &crowdRadius, 1},
+		{"avoidRadius", &avoidRadius, 1},
+		{"coheseRadius", &coheseRadius, 1},
+		{"lookAhead", &lookAhead, 1},
+		{"edgeMargin", &edgeMargin, 1},
+	}
+)
+
+// param is a tunable value listed in the HUD
+type param struct {
+	name  string
+	value *float64
+	step  float64
+}
+
+const (
+	segmentTool = iota
+	circleTool
+	polygonTool
+)
+
+const (
+	squareRenderer = iota
+	triangleRenderer
+	arrowRenderer
+	renderers
+)
+
+const trailLength = 24
+
+const (
+	wallEdges = iota
+	wrapEdges
+	bounceEdges
+	steerEdges
+	edgeModes
 )
 
 func init() {
@@ -45,15 +112,28 @@ func init() {
 }
 
 func setup() {
-	for x := 0; x < w; x += 15 {
-		avoids = append(avoids, newAvoid(pixel.V(float64(x+5), 50), 0, gray))
-		avoids = append(avoids, newAvoid(pixel.V(float64(x+5), fh-50), 0, gray))
-	}
+	var (
+		bl = pixel.V(50, 50)
+		br = pixel.V(fw-50, 50)
+		tr = pixel.V(fw-50, fh-50)
+		tl = pixel.V(50, fh-50)
+	)
 
-	for y := 0; y < h; y += 15 {
-		avoids = append(avoids, newAvoid(pixel.V(50, float64(y+5)), 0, gray))
-		avoids = append(avoids, newAvoid(pixel.V(fw-50, float64(y+5)), 0, gray))
+	walls = Avoids{
+		newSegment(bl, br, gray),
+		newSegment(br, tr, gray),
+		newSegment(tr, tl, gray),
+		newSegment(tl, bl, gray),
 	}
+
+	avoids = append(avoids,
+		newCircle(pixel.V(fw/4, fh/2), 40, gray),
+		newPolygon([]pixel.Vec{
+			pixel.V(fw*0.7, fh*0.35),
+			pixel.V(fw*0.8, fh*0.65),
+			pixel.V(fw*0.6, fh*0.6),
+		}, gray),
+	)
 }
 
 func run() {
@@ -68,6 +148,10 @@ func run() {
 
 	canvas := pixelgl.NewCanvas(win.Bounds())
 
+	imd := imdraw.New(nil)
+
+	txt := text.New(pixel.V(60, fh-70), text.Atlas7x13)
+
 	last := time.Now()
 
 	for !win.Closed() {
@@ -77,10 +161,26 @@ func run() {
 		win.SetClosed(win.JustPressed(pixelgl.KeyEscape) || win.JustPressed(pixelgl.KeyQ))
 
 		if win.JustPressed(pixelgl.KeyC) {
-			boids, avoids = nil, nil
+			boids, avoids, drawing = nil, nil, nil
 			setup()
 		}
 
+		if win.JustPressed(pixelgl.KeyZ) {
+			tool, drawing = segmentTool, nil
+		}
+
+		if win.JustPressed(pixelgl.KeyX) {
+			tool, drawing = circleTool, nil
+		}
+
+		if win.JustPressed(pixelgl.KeyV) {
+			tool, drawing = polygonTool, nil
+		}
+
+		if win.JustPressed(pixelgl.KeyBackspace) && len(avoids) > 0 {
+			avoids = avoids[:len(avoids)-1]
+		}
+
 		if win.Pressed(pixelgl.Key1) {
 			desireAmount = 1
 		}
@@ -97,20 +197,71 @@ func run() {
 			desireAmount = 20
 		}
 
-		if win.Pressed(pixelgl.KeyUp) {
-			desireAmount += 0.1
-			fmt.Println(desireAmount)
+		if win.JustPressed(pixelgl.KeyH) {
+			showHUD = !showHUD
+		}
+
+		if win.JustPressed(pixelgl.KeyR) {
+			renderer = (renderer + 1) % renderers
+		}
+
+		if win.JustPressed(pixelgl.KeyT) {
+			showTrails = !showTrails
 		}
 
-		if win.Pressed(pixelgl.KeyDown) {
-			desireAmount -= 0.1
-			fmt.Println(desireAmount)
+		if win.JustPressed(pixelgl.KeyE) {
+			edges = (edges + 1) % edgeModes
+		}
+
+		if win.JustPressed(pixelgl.KeyG) {
+			if rec == nil {
+				rec = newRecorder(time.Now().Format("boids-20060102-150405.gif"), 0)
+			} else {
+				stopRecording()
+			}
+		}
+
+		if win.JustPressed(pixelgl.KeyUp) {
+			selected = (selected + len(params) - 1) % len(params)
+		}
+
+		if win.JustPressed(pixelgl.KeyDown) {
+			selected = (selected + 1) % len(params)
+		}
+
+		if win.Pressed(pixelgl.KeyRight) {
+			params[selected].change(1)
+		}
+
+		if win.Pressed(pixelgl.KeyLeft) {
+			params[selected].change(-1)
 		}
 
 		pos := win.MousePosition()
 
 		if win.Pressed(pixelgl.KeyO) {
-			avoids = append(avoids, newAvoid(pos, 10, gray))
+			avoids = append(avoids, newCircle(pos, 2, gray))
+		}
+
+		switch tool {
+		case segmentTool, circleTool:
+			if win.JustPressed(pixelgl.MouseButtonRight) {
+				drawing = []pixel.Vec{pos}
+			}
+
+			if win.JustReleased(pixelgl.MouseButtonRight) && len(drawing) > 0 {
+				avoids = append(avoids, newAvoidFromTool(drawing[0], pos))
+				drawing = nil
+			}
+		case polygonTool:
+			if win.JustPressed(pixelgl.MouseButtonRight) {
+				drawing = append(drawing, pos)
+			}
+
+			if win.JustPressed(pixelgl.KeyEnter) && len(drawing) > 2 {
+				avoids = append(avoids, newPolygon(drawing, gray))
+				drawing = nil
+			}
 		}
 
 		if win.Pressed(pixelgl.MouseButtonLeft) {
@@ -127,22 +278,87 @@ func run() {
 
 		canvas.Draw(win, pixel.IM.Moved(win.Bounds().Center()))
 
+		imd.Clear()
+
+		for _, a := range obstacles {
+			a.draw(imd)
+		}
+
+		for _, b := range boids {
+			if showTrails {
+				b.drawTrail(imd)
+			}
+
+			b.drawShape(imd)
+		}
+
+		drawPreview(imd, pos)
+
+		imd.Draw(win)
+
+		if rec != nil {
+			capture(win)
+		}
+
+		if showHUD {
+			drawHUD(txt, dt)
+
+			txt.Draw(win, pixel.IM)
+		}
+
 		win.Update()
 	}
 }
 
+func (p param) change(direction float64) {
+	*p.value = math.Max(0, *p.value+direction*p.step)
+
+	if p.value == &friendRadius {
+		deriveRadii()
+	}
+}
+
+// deriveRadii sets the crowd and cohese radius from the friend radius,
+// they can still be tuned on their own afterwards
+func deriveRadii() {
+	crowdRadius = friendRadius / 1.4
+	coheseRadius = friendRadius / 0.9
+}
+
+func drawHUD(txt *text.Text, dt float64) {
+	txt.Clear()
+
+	for i, p := range params {
+		cursor := " "
+
+		if i == selected {
+			cursor = ">"
+		}
+
+		fmt.Fprintf(txt, "%s %-12s %6.2f\n", cursor, p.name, *p.value)
+	}
+
+	fmt.Fprintf(txt, "\n  edges  %s\n", edgeNames[edges])
+	fmt.Fprintf(txt, "  boids  %d\n  frame  %.2fms\n", len(boids), dt*1000)
+
+	if rec != nil {
+		fmt.Fprintf(txt, "  rec    %d\n", len(rec.anim.Image))
+	}
+}
+
 func drawFrame(canvas *pixelgl.Canvas) {
 	buffer := image.NewRGBA(image.Rect(0, 0, w, h))
 
-	for _, a := range avoids {
-		a.draw(buffer)
+	obstacles = avoids
+
+	if edges == wallEdges {
+		obstacles = append(walls[:len(walls):len(walls)], avoids...)
 	}
 
 	alive := Boids{}
 
 	for _, b := range boids {
 		b.increment()
-		b.wrap()
 
 		if b.think == 0 {
 			b.updateFriends()
@@ -151,8 +367,13 @@ func drawFrame(canvas *pixelgl.Canvas) {
 		b.flock()
 
 		b.updatePosition()
+		b.handleEdges()
 
-		b.draw(buffer)
+		b.record()
+
+		if renderer == squareRenderer {
+			b.draw(buffer)
+		}
 
 		if b.life > 0 {
 			alive = append(alive, b)
@@ -164,6 +385,30 @@ func drawFrame(canvas *pixelgl.Canvas) {
 	canvas.SetPixels(buffer.Pix)
 }
 
+// capture records the pixels of the window, including the obstacles,
+// trails and shapes drawn on top of the canvas, but not the HUD
+func capture(win *pixelgl.Window) {
+	rec.capture(&image.RGBA{
+		Pix:    win.Canvas().Pixels(),
+		Stride: 4 * w,
+		Rect:   image.Rect(0, 0, w, h),
+	})
+
+	if rec.done() {
+		stopRecording()
+	}
+}
+
+func stopRecording() {
+	if err := rec.save(); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println("saved", rec.path)
+	}
+
+	rec = nil
+}
+
 type Boids []*boid
 
 type boid struct {
@@ -175,6 +420,11 @@ type boid struct {
 	color         color.RGBA
 	originalColor color.RGBA
 	friends       []*boid
+
+	// trail is a ring buffer of recent positions, oldest at trailHead
+	trail     [trailLength]pixel.Vec
+	trailHead int
+	trailLen  int
 }
 
 func newBoid(x, y, angle, speed float64, c color.RGBA) *boid {
@@ -217,9 +467,18 @@ func (b *boid) increment() {
 	b.think = (b.think + 1) % 5
 }
 
-func (b *boid) wrap() {
-	b.position.X = float64(int(b.position.X+fw) % w)
-	b.position.Y = float64(int(b.position.Y+fh) % h)
+// handleEdges keeps the boid inside the window according to the edge mode,
+// walls and steering fall back to wrapping should a boid slip through
+func (b *boid) handleEdges() {
+	if edges == bounceEdges {
+		b.position.X, b.velocity.X = reflect(b.position.X, b.velocity.X, fw)
+		b.position.Y, b.velocity.Y = reflect(b.position.Y, b.velocity.Y, fh)
+
+		return
+	}
+
+	b.position.X = wrap(b.position.X, fw)
+	b.position.Y = wrap(b.position.Y, fh)
 }
 
 func (b *boid) updatePosition() {
@@ -315,18 +574,75 @@ func (b *boid) getAvoidDir() pixel.Vec {
 func (b *boid) getAvoidObjects() pixel.Vec {
 	steer := pixel.V(0, 0)
 
-	for _, f := range avoids {
-		d := dist(b.position, f.position)
+	for _, a := range obstacles {
+		c := a.closest(b.position)
+		d := b.position.Sub(c).Len()
 
-		if d > 0 && d < avoidRadius {
-			diff := div(b.position.Sub(f.position).Unit(), d)
-			steer = steer.Add(diff)
+		if d == 0 {
+			continue
+		}
+
+		// Push straight out towards the surface when inside the obstacle
+		if a.contains(b.position) {
+			steer = steer.Add(c.Sub(b.position).Unit().Scaled(desireAmount))
+
+			continue
+		}
+
+		if d < avoidRadius {
+			steer = steer.Add(div(b.position.Sub(c).Unit(), d))
 		}
 	}
 
 	return steer
 }
 
+// getAvoidAhead casts a ray along the velocity and steers away
+// from the nearest obstacle surface it would run into
+func (b *boid) getAvoidAhead() pixel.Vec {
+	if b.velocity.Len() == 0 {
+		return pixel.V(0, 0)
+	}
+
+	var (
+		ahead = b.position.Add(b.velocity.Unit().Scaled(lookAhead))
+		best  = math.Inf(1)
+		steer = pixel.V(0, 0)
+	)
+
+	for _, a := range obstacles {
+		if t, n, ok := a.intersect(b.position, ahead); ok && t < best {
+			best = t
+
+			// Steer along the normal, harder the closer the hit is
+			steer = n.Scaled(desireAmount * (1 - t))
+		}
+	}
+
+	return steer
+}
+
+// getAvoidEdges steers away from the window edges in steer mode,
+// harder the further into the margin the boid is
+func (b *boid) getAvoidEdges() pixel.Vec {
+	if edges != steerEdges || edgeMargin == 0 {
+		return pixel.V(0, 0)
+	}
+
+	push := func(p, max float64) float64 {
+		switch {
+		case p < edgeMargin:
+			return (edgeMargin - p) / edgeMargin
+		case p > max-edgeMargin:
+			return -(p - (max - edgeMargin)) / edgeMargin
+		default:
+			return 0
+		}
+	}
+
+	return pixel.V(push(b.position.X, fw), push(b.position.Y, fh)).Scaled(maxSpeed)
+}
+
 func (b *boid) getCohesion() pixel.Vec {
 	sum := pixel.V(0, 0)
 
@@ -378,6 +694,8 @@ func (b *boid) flock() {
 		cohesion     = b.getCohesion().Scaled(1)
 		avoidDir     = b.getAvoidDir().Scaled(1)
 		avoidObjects = b.getAvoidObjects().Scaled(1)
+		avoidAhead   = b.getAvoidAhead().Scaled(1)
+		avoidEdges   = b.getAvoidEdges().Scaled(1)
 
 		noise = pixel.V(rand.Float64()*2-1, rand.Float64()*2-1).Scaled(0.05)
 	)
@@ -385,6 +703,8 @@ func (b *boid) flock() {
 	b.move(align)
 	b.move(avoidDir)
 	b.move(avoidObjects)
+	b.move(avoidAhead)
+	b.move(avoidEdges)
 	b.move(noise)
 	b.move(cohesion)
 
@@ -401,24 +721,404 @@ func (b *boid) draw(m *image.RGBA) {
 	draw.Draw(m, r, &image.Uniform{b.color}, image.ZP, draw.Src)
 }
 
-type Avoids []*avoid
+func (b *boid) record() {
+	b.trail[(b.trailHead+b.trailLen)%trailLength] = b.position
+
+	if b.trailLen < trailLength {
+		b.trailLen++
+	} else {
+		b.trailHead = (b.trailHead + 1) % trailLength
+	}
+}
+
+func (b *boid) drawTrail(imd *imdraw.IMDraw) {
+	for i := 1; i < b.trailLen; i++ {
+		var (
+			p = b.trail[(b.trailHead+i-1)%trailLength]
+			q = b.trail[(b.trailHead+i)%trailLength]
+		)
+
+		// Do not connect the points on either side of a wrap around
+		if dist(p, q) > fw/2 {
+			continue
+		}
+
+		imd.Color = fade(b.color, float64(i)/float64(b.trailLen))
+		imd.Push(p, q)
+		imd.Line(1)
+	}
+}
+
+func (b *boid) drawShape(imd *imdraw.IMDraw) {
+	if renderer == squareRenderer {
+		return
+	}
+
+	var (
+		s = float64(b.size) * 3
+		u = b.velocity.Unit()
+		n = u.Normal().Scaled(s)
+
+		tip   = b.position.Add(u.Scaled(s * 2))
+		back  = b.position.Sub(u.Scaled(s))
+		left  = back.Add(n)
+		right = back.Sub(n)
+	)
+
+	imd.Color = b.color
+
+	switch renderer {
+	case triangleRenderer:
+		imd.Push(tip, left, right)
+		imd.Polygon(0)
+	case arrowRenderer:
+		imd.Push(left, tip, right)
+		imd.Line(1.5)
+	}
+}
+
+type Avoids []avoid
+
+// avoid is an obstacle the boids steer around
+type avoid interface {
+	// closest returns the point on the obstacle closest to p
+	closest(p pixel.Vec) pixel.Vec
+
+	// contains reports whether p is inside the obstacle
+	contains(p pixel.Vec) bool
+
+	// intersect returns the first hit along the segment a→b as a
+	// fraction of its length, together with the surface normal
+	intersect(a, b pixel.Vec) (float64, pixel.Vec, bool)
+
+	draw(imd *imdraw.IMDraw)
+}
+
+func newAvoidFromTool(a, b pixel.Vec) avoid {
+	if tool == circleTool {
+		return newCircle(a, math.Max(a.Sub(b).Len(), 2), gray)
+	}
+
+	return newSegment(a, b, gray)
+}
+
+func drawPreview(imd *imdraw.IMDraw, pos pixel.Vec) {
+	if len(drawing) == 0 {
+		return
+	}
+
+	imd.Color = blue
+
+	switch tool {
+	case segmentTool:
+		imd.Push(drawing[0], pos)
+		imd.Line(2)
+	case circleTool:
+		imd.Push(drawing[0])
+		imd.Circle(math.Max(drawing[0].Sub(pos).Len(), 2), 1)
+	case polygonTool:
+		imd.Push(drawing...)
+		imd.Push(pos)
+		imd.Line(1)
+	}
+}
+
+type segment struct {
+	a, b  pixel.Vec
+	color color.RGBA
+}
+
+func newSegment(a, b pixel.Vec, c color.RGBA) *segment {
+	return &segment{a: a, b: b, color: c}
+}
+
+func (s *segment) closest(p pixel.Vec) pixel.Vec {
+	return closestOnSegment(p, s.a, s.b)
+}
+
+func (s *segment) contains(p pixel.Vec) bool {
+	return false
+}
+
+func (s *segment) intersect(a, b pixel.Vec) (float64, pixel.Vec, bool) {
+	t, ok := intersectSegments(a, b, s.a, s.b)
+	if !ok {
+		return 0, pixel.ZV, false
+	}
+
+	n := s.b.Sub(s.a).Normal().Unit()
+
+	if n.Dot(b.Sub(a)) > 0 {
+		n = n.Scaled(-1)
+	}
+
+	return t, n, true
+}
+
+func (s *segment) draw(imd *imdraw.IMDraw) {
+	imd.Color = s.color
+	imd.Push(s.a, s.b)
+	imd.Line(3)
+}
+
+type circle struct {
+	center pixel.Vec
+	radius float64
+	color  color.RGBA
+}
+
+func newCircle(c pixel.Vec, r float64, col color.RGBA) *circle {
+	return &circle{center: c, radius: r, color: col}
+}
+
+func (c *circle) closest(p pixel.Vec) pixel.Vec {
+	d := p.Sub(c.center)
+
+	if d.Len() == 0 {
+		return c.center.Add(pixel.V(c.radius, 0))
+	}
+
+	return c.center.Add(d.Unit().Scaled(c.radius))
+}
+
+func (c *circle) contains(p pixel.Vec) bool {
+	return p.Sub(c.center).Len() < c.radius
+}
+
+func (c *circle) intersect(a, b pixel.Vec) (float64, pixel.Vec, bool) {
+	var (
+		d  = b.Sub(a)
+		f  = a.Sub(c.center)
+		qa = d.Dot(d)
+		qb = 2 * f.Dot(d)
+		qc = f.Dot(f) - c.radius*c.radius
+	)
+
+	disc := qb*qb - 4*qa*qc
+
+	if qa == 0 || disc < 0 || qc < 0 {
+		return 0, pixel.ZV, false
+	}
+
+	t := (-qb - math.Sqrt(disc)) / (2 * qa)
+
+	if t < 0 || t > 1 {
+		return 0, pixel.ZV, false
+	}
+
+	return t, a.Add(d.Scaled(t)).Sub(c.center).Unit(), true
+}
+
+func (c *circle) draw(imd *imdraw.IMDraw) {
+	imd.Color = c.color
+	imd.Push(c.center)
+	imd.Circle(c.radius, 0)
+}
+
+type polygon struct {
+	points []pixel.Vec
+	color  color.RGBA
+}
+
+func newPolygon(points []pixel.Vec, c color.RGBA) *polygon {
+	return &polygon{points: points, color: c}
+}
+
+func (pg *polygon) edge(i int) (pixel.Vec, pixel.Vec) {
+	return pg.points[i], pg.points[(i+1)%len(pg.points)]
+}
+
+func (pg *polygon) closest(p pixel.Vec) pixel.Vec {
+	var (
+		best = math.Inf(1)
+		c    pixel.Vec
+	)
+
+	for i := range pg.points {
+		a, b := pg.edge(i)
+
+		q := closestOnSegment(p, a, b)
+
+		if d := q.Sub(p).Len(); d < best {
+			best, c = d, q
+		}
+	}
+
+	return c
+}
+
+func (pg *polygon) contains(p pixel.Vec) bool {
+	inside := false
+
+	for i := range pg.points {
+		a, b := pg.edge(i)
+
+		if (a.Y > p.Y) != (b.Y > p.Y) &&
+			p.X < (b.X-a.X)*(p.Y-a.Y)/(b.Y-a.Y)+a.X {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+func (pg *polygon) intersect(a, b pixel.Vec) (float64, pixel.Vec, bool) {
+	var (
+		best = math.Inf(1)
+		n    pixel.Vec
+		hit  bool
+	)
+
+	for i := range pg.points {
+		ea, eb := pg.edge(i)
+
+		if t, en, ok := newSegment(ea, eb, pg.color).intersect(a, b); ok && t < best {
+			best, n, hit = t, en, true
+		}
+	}
+
+	return best, n, hit
+}
+
+func (pg *polygon) draw(imd *imdraw.IMDraw) {
+	imd.Color = pg.color
+	imd.Push(pg.points...)
+	imd.Polygon(0)
+}
+
+func closestOnSegment(p, a, b pixel.Vec) pixel.Vec {
+	ab := b.Sub(a)
 
-func newAvoid(p pixel.Vec, s float64, c color.RGBA) *avoid {
-	return &avoid{position: p, size: s, color: c}
+	l := ab.Dot(ab)
+
+	if l == 0 {
+		return a
+	}
+
+	t := math.Max(0, math.Min(1, p.Sub(a).Dot(ab)/l))
+
+	return a.Add(ab.Scaled(t))
 }
 
-type avoid struct {
-	position pixel.Vec
-	size     float64
-	color    color.RGBA
+// intersectSegments returns the fraction along p→p2 where it crosses q→q2
+func intersectSegments(p, p2, q, q2 pixel.Vec) (float64, bool) {
+	var (
+		r = p2.Sub(p)
+		s = q2.Sub(q)
+		d = r.Cross(s)
+	)
+
+	if d == 0 {
+		return 0, false
+	}
+
+	t := q.Sub(p).Cross(s) / d
+	u := q.Sub(p).Cross(r) / d
+
+	if t < 0 || t > 1 || u < 0 || u > 1 {
+		return 0, false
+	}
+
+	return t, true
 }
 
-func (a *avoid) draw(m *image.RGBA) {
-	x, y := int(a.position.X), int(a.position.Y)
+// fade scales the premultiplied color c by t
+func fade(c color.RGBA, t float64) color.RGBA {
+	return color.RGBA{
+		uint8(float64(c.R) * t),
+		uint8(float64(c.G) * t),
+		uint8(float64(c.B) * t),
+		uint8(float64(c.A) * t),
+	}
+}
 
-	r := image.Rect(x-2, y-3, x+2, y+1)
+// recorder captures frames into an animated GIF
+type recorder struct {
+	path   string
+	frames int
+	anim   gif.GIF
+}
 
-	draw.Draw(m, r, &image.Uniform{a.color}, image.ZP, draw.Src)
+func newRecorder(path string, frames int) *recorder {
+	return &recorder{path: path, frames: frames}
+}
+
+// capture quantises m using a palette built from the colors on screen,
+// flipping it vertically since the canvas has its origin at the bottom
+func (r *recorder) capture(m *image.RGBA) {
+	var (
+		b = m.Bounds()
+		p = image.NewPaletted(b, recordPalette())
+		f = image.NewRGBA(b)
+	)
+
+	for y := 0; y < b.Dy(); y++ {
+		copy(f.Pix[y*f.Stride:(y+1)*f.Stride], m.Pix[(b.Dy()-1-y)*m.Stride:])
+	}
+
+	draw.Draw(p, b, f, b.Min, draw.Src)
+
+	r.anim.Image = append(r.anim.Image, p)
+	r.anim.Delay = append(r.anim.Delay, 2)
+}
+
+// done reports if the requested number of frames have been captured
+func (r *recorder) done() bool {
+	return r.frames > 0 && len(r.anim.Image) >= r.frames
+}
+
+func (r *recorder) save() error {
+	f, err := os.Create(r.path)
+	if err != nil {
+		return err
+	}
+
+	if err := gif.EncodeAll(f, &r.anim); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// recordPalette returns black, the obstacle and preview colors and the
+// distinct boid colors, followed by faded boid colors for the trails.
+// Any colors beyond the 256 that fit are mapped to their closest match.
+func recordPalette() color.Palette {
+	var (
+		p    = color.Palette{}
+		seen = map[color.RGBA]bool{}
+	)
+
+	add := func(c color.RGBA) {
+		if len(p) < 256 && !seen[c] {
+			seen[c] = true
+
+			p = append(p, c)
+		}
+	}
+
+	add(color.RGBA{0, 0, 0, 255})
+	add(gray)
+	add(blue)
+
+	for _, b := range boids {
+		add(b.color)
+	}
+
+	if showTrails {
+		for _, t := range []float64{0.75, 0.5, 0.25} {
+			for _, b := range boids {
+				c := fade(b.color, t)
+				c.A = 255
+
+				add(c)
+			}
+		}
+	}
+
+	return p
 }
 
 func randomColor() color.RGBA {
@@ -438,6 +1138,29 @@ func flip() float64 {
 	return -1.0
 }
 
+// wrap returns v wrapped into [0, max) keeping the fractional part
+func wrap(v, max float64) float64 {
+	v = math.Mod(v, max)
+
+	if v < 0 {
+		v += max
+	}
+
+	return v
+}
+
+// reflect mirrors v back into [0, max] and flips the velocity if needed
+func reflect(v, vel, max float64) (float64, float64) {
+	switch {
+	case v < 0:
+		return -v, math.Abs(vel)
+	case v > max:
+		return 2*max - v, -math.Abs(vel)
+	default:
+		return v, vel
+	}
+}
+
 func dist(a, b pixel.Vec) float64 {
 	return math.Abs(a.X-b.X) + math.Abs(a.Y-b.Y)
 }
@@ -450,5 +1173,16 @@ func div(v pixel.Vec, d float64) pixel.Vec {
 }
 
 func main() {
+	var (
+		record = flag.String("record", "", "record the frames to this GIF file")
+		frames = flag.Int("frames", 300, "number of frames to record")
+	)
+
+	flag.Parse()
+
+	if *record != "" {
+		rec = newRecorder(*record, *frames)
+	}
+
 	pixelgl.Run(run)
 }
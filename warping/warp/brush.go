@@ -0,0 +1,152 @@
+package warp
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	pixel "github.com/faiface/pixel"
+)
+
+// Brush is a way of displacing the field around a point
+type Brush int
+
+// Brushes
+const (
+	Push  Brush = iota // Drag the image along with the pointer
+	Swirl              // Twist the image around the pointer
+	Pinch              // Pull the image in towards the pointer
+
+	brushCount
+)
+
+var brushNames = [brushCount]string{"push", "swirl", "pinch"}
+
+func (b Brush) String() string {
+	return brushNames[b]
+}
+
+// Next returns the brush after b, wrapping around
+func (b Brush) Next() Brush {
+	return (b + 1) % brushCount
+}
+
+// Field is a grid of displacements, in pixels, that is added to the
+// position of each pixel before it is warped by the pattern
+type Field struct {
+	w, h int
+	cell float64
+	d    []pixel.Vec
+}
+
+// NewField returns an empty field covering w by h pixels,
+// with one displacement for every cell by cell pixels
+func NewField(w, h, cell int) *Field {
+	fw, fh := w/cell+2, h/cell+2
+
+	return &Field{w: fw, h: fh, cell: float64(cell), d: make([]pixel.Vec, fw*fh)}
+}
+
+// fieldJSON is how a field is saved along with a captured frame
+type fieldJSON struct {
+	W    int         `json:"w"`
+	H    int         `json:"h"`
+	Cell float64     `json:"cell"`
+	D    []pixel.Vec `json:"d"`
+}
+
+// MarshalJSON encodes the cells of the field
+func (f *Field) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fieldJSON{f.w, f.h, f.cell, f.d})
+}
+
+// UnmarshalJSON decodes a field encoded by MarshalJSON
+func (f *Field) UnmarshalJSON(b []byte) error {
+	var j fieldJSON
+
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+
+	if j.W <= 0 || j.H <= 0 || j.Cell <= 0 || len(j.D) != j.W*j.H {
+		return fmt.Errorf("warp: invalid field of %dx%d cells with %d displacements", j.W, j.H, len(j.D))
+	}
+
+	f.w, f.h, f.cell, f.d = j.W, j.H, j.Cell, j.D
+
+	return nil
+}
+
+// At returns the displacement at x, y, interpolated between the cells
+func (f *Field) At(x, y float64) pixel.Vec {
+	cx, cy := x/f.cell, y/f.cell
+
+	x0, y0 := int(math.Floor(cx)), int(math.Floor(cy))
+	tx, ty := cx-float64(x0), cy-float64(y0)
+
+	top := pixel.Lerp(f.cellAt(x0, y0), f.cellAt(x0+1, y0), tx)
+	bottom := pixel.Lerp(f.cellAt(x0, y0+1), f.cellAt(x0+1, y0+1), tx)
+
+	return pixel.Lerp(top, bottom, ty)
+}
+
+func (f *Field) cellAt(x, y int) pixel.Vec {
+	if x < 0 || y < 0 || x >= f.w || y >= f.h {
+		return pixel.ZV
+	}
+
+	return f.d[y*f.w+x]
+}
+
+// Decay scales every displacement by t, so the field fades back to nothing
+func (f *Field) Decay(t float64) {
+	for i := range f.d {
+		f.d[i] = f.d[i].Scaled(t)
+	}
+}
+
+// Clear removes all displacements
+func (f *Field) Clear() {
+	for i := range f.d {
+		f.d[i] = pixel.ZV
+	}
+}
+
+// Apply displaces the cells within radius of p with brush b, delta is
+// how far the pointer moved since the last time the brush was applied
+func (f *Field) Apply(b Brush, p, delta pixel.Vec, radius, strength float64) {
+	var (
+		x0 = int((p.X - radius) / f.cell)
+		y0 = int((p.Y - radius) / f.cell)
+		x1 = int((p.X + radius) / f.cell)
+		y1 = int((p.Y + radius) / f.cell)
+	)
+
+	for y := max(y0, 0); y <= y1 && y < f.h; y++ {
+		for x := max(x0, 0); x <= x1 && x < f.w; x++ {
+			r := pixel.V(float64(x)*f.cell, float64(y)*f.cell).Sub(p)
+
+			l := r.Len()
+			if l >= radius {
+				continue
+			}
+
+			// Smooth falloff from the center to the edge of the brush
+			t := 1 - l/radius
+			t *= t * strength
+
+			var d pixel.Vec
+
+			switch b {
+			case Push:
+				d = delta.Scaled(-t)
+			case Swirl:
+				d = r.Normal().Scaled(t * 0.1)
+			case Pinch:
+				d = r.Scaled(t * 0.1)
+			}
+
+			f.d[y*f.w+x] = f.d[y*f.w+x].Add(d)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package warp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Frames is a sequence of images, used to warp video
+type Frames interface {
+	// Next returns the next frame, or io.EOF when there are no more
+	Next() (*image.RGBA, error)
+}
+
+// OpenFrames returns the images in the directory fn as frames,
+// or the MJPEG stream on stdin if fn is "-"
+func OpenFrames(fn string) (Frames, error) {
+	if fn == "-" {
+		return MJPEGFrames(os.Stdin), nil
+	}
+
+	return DirFrames(fn)
+}
+
+type dirFrames struct {
+	fns []string
+	i   int
+}
+
+// DirFrames returns the images in dir as frames, ordered by file name.
+// The frames loop, so Next never returns io.EOF.
+func DirFrames(dir string) (Frames, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var fns []string
+
+	for _, e := range entries {
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".png", ".jpg", ".jpeg", ".gif":
+			fns = append(fns, filepath.Join(dir, e.Name()))
+		}
+	}
+
+	if len(fns) == 0 {
+		return nil, fmt.Errorf("no images in %s", dir)
+	}
+
+	sort.Strings(fns)
+
+	return &dirFrames{fns: fns}, nil
+}
+
+func (d *dirFrames) Next() (*image.RGBA, error) {
+	fn := d.fns[d.i]
+
+	d.i = (d.i + 1) % len(d.fns)
+
+	return LoadImage(fn)
+}
+
+// Skip advances f by n frames, the frames in a directory
+// are skipped without decoding them
+func Skip(f Frames, n int) error {
+	if d, ok := f.(*dirFrames); ok {
+		d.i = (d.i + n) % len(d.fns)
+
+		return nil
+	}
+
+	for ; n > 0; n-- {
+		if _, err := f.Next(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// JPEG markers needed to find where each image in the stream ends
+const (
+	markerTEM  = 0x01
+	markerRST0 = 0xD0
+	markerRST7 = 0xD7
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+)
+
+type mjpegFrames struct {
+	r *bufio.Reader
+}
+
+// MJPEGFrames returns the JPEG images concatenated in r as frames,
+// which is what for example ffmpeg writes when using -f mjpeg
+func MJPEGFrames(r io.Reader) Frames {
+	return &mjpegFrames{r: bufio.NewReader(r)}
+}
+
+func (s *mjpegFrames) Next() (*image.RGBA, error) {
+	var buf bytes.Buffer
+
+	// Skip anything before the start of the next image
+	for prev := byte(0); ; {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		if prev == 0xFF && b == markerSOI {
+			break
+		}
+
+		prev = b
+	}
+
+	buf.Write([]byte{0xFF, markerSOI})
+
+	m, err := s.marker(&buf)
+
+	for err == nil {
+		switch {
+		case m == markerEOI:
+			img, err := jpeg.Decode(&buf)
+			if err != nil {
+				return nil, err
+			}
+
+			return toRGBA(img), nil
+		case m == markerTEM || (m >= markerRST0 && m <= markerRST7):
+			m, err = s.marker(&buf)
+		case m == markerSOS:
+			if err = s.segment(&buf); err == nil {
+				m, err = s.scan(&buf)
+			}
+		default:
+			if err = s.segment(&buf); err == nil {
+				m, err = s.marker(&buf)
+			}
+		}
+	}
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return nil, err
+}
+
+// marker reads the next marker, skipping any fill bytes
+func (s *mjpegFrames) marker(buf *bytes.Buffer) (byte, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	if b != 0xFF {
+		return 0, fmt.Errorf("expected JPEG marker, got 0x%02x", b)
+	}
+
+	for b == 0xFF {
+		if b, err = s.r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+
+	buf.Write([]byte{0xFF, b})
+
+	return b, nil
+}
+
+// segment copies a segment, prefixed by its length, into buf
+func (s *mjpegFrames) segment(buf *bytes.Buffer) error {
+	var l [2]byte
+
+	if _, err := io.ReadFull(s.r, l[:]); err != nil {
+		return err
+	}
+
+	buf.Write(l[:])
+
+	n := int64(l[0])<<8 | int64(l[1])
+
+	_, err := io.CopyN(buf, s.r, n-2)
+
+	return err
+}
+
+// scan copies entropy coded data into buf, returning the marker after it
+func (s *mjpegFrames) scan(buf *bytes.Buffer) (byte, error) {
+	for {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		buf.WriteByte(b)
+
+		if b != 0xFF {
+			continue
+		}
+
+		// Skip any fill bytes before the byte after 0xFF
+		for b == 0xFF {
+			if b, err = s.r.ReadByte(); err != nil {
+				return 0, err
+			}
+
+			buf.WriteByte(b)
+		}
+
+		// Stuffed 0xFF bytes and restart markers are part of the data
+		if b == 0x00 || (b >= markerRST0 && b <= markerRST7) {
+			continue
+		}
+
+		return b, nil
+	}
+}
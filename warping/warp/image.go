@@ -0,0 +1,83 @@
+package warp
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// LoadImage decodes the image in fn into an *image.RGBA,
+// an empty fn returns the XOR pattern instead
+func LoadImage(fn string) (*image.RGBA, error) {
+	if fn == "" {
+		return XorImage(400, 300), nil
+	}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return toRGBA(m), nil
+}
+
+func toRGBA(m image.Image) *image.RGBA {
+	if rgba, ok := m.(*image.RGBA); ok {
+		return rgba
+	}
+
+	rgba := image.NewRGBA(m.Bounds())
+
+	draw.Draw(rgba, rgba.Bounds(), m, m.Bounds().Min, draw.Src)
+
+	return rgba
+}
+
+// XorImage returns a w by h image of the classic XOR pattern
+func XorImage(w, h int) *image.RGBA {
+	var (
+		m = image.NewRGBA(image.Rect(0, 0, w, h))
+		p = Xor{w, h}
+	)
+
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			m.SetRGBA(x, y, p.RGBAAt(x, y))
+		}
+	}
+
+	return m
+}
+
+// Xor is the XOR pattern of XorImage, computed for every pixel
+// instead of stored, so that it can be as large as any source
+type Xor struct {
+	W, H int
+}
+
+// Bounds returns the bounds of the pattern
+func (p Xor) Bounds() image.Rectangle {
+	return image.Rect(0, 0, p.W, p.H)
+}
+
+// RGBAAt returns the color of the pattern at x, y
+func (p Xor) RGBAAt(x, y int) color.RGBA {
+	v := uint8(x ^ y)
+
+	if x >= p.W/2 {
+		return color.RGBA{v, v, v % 128, 255}
+	}
+
+	return color.RGBA{v, v % 128, v, 255}
+}
@@ -0,0 +1,344 @@
+package warp
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+
+	pixel "github.com/faiface/pixel"
+)
+
+// ModeFunc colors a single sample of the pattern
+type ModeFunc func(s *Scene, p Sample) color.RGBA
+
+// Mode is a named ModeFunc, the index is its position in the
+// registry which is also the number used to select it
+type Mode struct {
+	Index       int
+	Name        string
+	Description string
+	Func        ModeFunc
+}
+
+var modes []Mode
+
+// RegisterMode makes a mode available by name and by its index,
+// it panics if a mode with the same name is already registered
+func RegisterMode(m Mode) {
+	if _, ok := LookupMode(m.Name); ok {
+		panic(fmt.Sprintf("warp: mode %q registered twice", m.Name))
+	}
+
+	m.Index = len(modes)
+
+	modes = append(modes, m)
+}
+
+// Modes returns all registered modes in the order they were registered
+func Modes() []Mode {
+	return append([]Mode(nil), modes...)
+}
+
+// LookupMode returns the mode registered with the given name or index
+func LookupMode(s string) (Mode, bool) {
+	if i, err := strconv.Atoi(s); err == nil {
+		if i >= 0 && i < len(modes) {
+			return modes[i], true
+		}
+
+		return Mode{}, false
+	}
+
+	for _, m := range modes {
+		if m.Name == s {
+			return m, true
+		}
+	}
+
+	return Mode{}, false
+}
+
+func init() {
+	RegisterMode(Mode{
+		Name:        "source",
+		Description: "Source image without any warping",
+		Func: func(s *Scene, p Sample) color.RGBA {
+			return s.SourceAt(p.X, p.Y)
+		},
+	})
+
+	RegisterMode(Mode{
+		Name:        "warped",
+		Description: "Source warped by pattern",
+		Func: func(s *Scene, p Sample) color.RGBA {
+			return p.C
+		},
+	})
+
+	RegisterMode(Mode{
+		Name:        "glow",
+		Description: "Source warped by pattern, glowing edges",
+		Func: func(s *Scene, p Sample) color.RGBA {
+			c := p.C
+
+			if p.V > 0.1 {
+				c = s.SourceAt(p.X, p.Y)
+			} else if p.V > -0.1 {
+				c = color.RGBA{c.R, c.G / 2, c.B / 4, 25}
+			}
+
+			return c
+		},
+	})
+
+	RegisterMode(Mode{
+		Name:        "grayscale",
+		Description: "Source warped by pattern, in grayscale",
+		Func: func(s *Scene, p Sample) color.RGBA {
+			a := luminance(p.C)
+
+			return color.RGBA{a, a, a, 255}
+		},
+	})
+
+	RegisterMode(Mode{
+		Name:        "black-and-white",
+		Description: "Black and white by pattern",
+		Func: func(s *Scene, p Sample) color.RGBA {
+			if uv := uint8(int(p.V*255) % 255); uv > 127 {
+				return color.RGBA{255, 255, 255, 255}
+			}
+
+			return color.RGBA{0, 0, 0, 255}
+		},
+	})
+
+	RegisterMode(Mode{
+		Name:        "see-through",
+		Description: "See through black and white",
+		Func: func(s *Scene, p Sample) color.RGBA {
+			uv := uint8(int(p.V*255) % 255)
+
+			switch {
+			case uv > 32 && uv < 128:
+				a := luminance(p.C)
+
+				return color.RGBA{a, a, a, 255}
+			case uv > 64:
+				return color.RGBA{255, 255, 255, 255}
+			default:
+				return color.RGBA{0, 0, 0, 255}
+			}
+		},
+	})
+
+	RegisterMode(Mode{
+		Name:        "ramp",
+		Description: "Color ramp by v, q and k",
+		Func: func(s *Scene, p Sample) color.RGBA {
+			return ramp(p.V, p.Q, p.K)
+		},
+	})
+
+	RegisterMode(Mode{
+		Name:        "lit",
+		Description: "Source warped by pattern, lit by the pattern as a height map",
+		Func: func(s *Scene, p Sample) color.RGBA {
+			return shade(s, p.C, p.P, p.V)
+		},
+	})
+
+	RegisterMode(Mode{
+		Name:        "blend",
+		Description: "Source and blend image mixed by pattern",
+		Func: func(s *Scene, p Sample) color.RGBA {
+			return mix(p.C, s.BlendAt(p.WX, p.WY), smoothstep(-0.3, 0.3, p.V))
+		},
+	})
+
+	RegisterMode(Mode{
+		Name:        "experimental",
+		Description: "Grayscale source tinted by the signs of q, k and v",
+		Func: func(s *Scene, p Sample) color.RGBA {
+			a := luminance(s.SourceAt(p.X, p.Y))
+
+			switch {
+			case p.Q.Y > 0 && p.V > 0:
+				return color.RGBA{255, a, a, 255}
+			case p.Q.Y < 0 && p.V < 0:
+				return color.RGBA{0, a, 255, 255}
+			case p.Q.X > 0 && p.K.X < 0:
+				return color.RGBA{255, 255, a, 255}
+			case p.Q.X > 0 && p.K.Y > 0:
+				return color.RGBA{a, 255, 255, 255}
+			default:
+				return color.RGBA{a, a, a, 255}
+			}
+		},
+	})
+
+	RegisterMode(Mode{
+		Name:        "offset-subtract",
+		Description: "Pattern subtracted from the warped source, as in the variations",
+		Func: func(s *Scene, p Sample) color.RGBA {
+			var (
+				c  = p.C
+				uv = wrapByte(math.Mod(math.Abs(p.V)*255, 255))
+				o  = wrapByte(p.Q.X * float64(s.Source.Bounds().Dx()))
+
+				r, g, b uint8
+			)
+
+			if uv < c.R {
+				r = c.R - uv + o
+			}
+
+			if uv < c.G {
+				g = c.G - uv + o
+			}
+
+			if uv < c.B {
+				b = c.B - uv + o
+			}
+
+			return color.RGBA{r, g, b, 255}
+		},
+	})
+
+	RegisterMode(Mode{
+		Name:        "offset-add",
+		Description: "Pattern added to the warped source, as in the variations",
+		Func: func(s *Scene, p Sample) color.RGBA {
+			var (
+				c  = p.C
+				uv = uint8(int(math.Abs(p.V)*255) % 20)
+
+				r, g, b uint8
+			)
+
+			if nr := (255 - c.R) + uv; nr < 255 {
+				r = uv + c.R
+			}
+
+			if ng := (255 - c.G) + uv; ng < 255 {
+				g = uv + c.G
+			}
+
+			if nb := (255 - c.B) + uv; nb < 255 {
+				b = uv + c.B
+			}
+
+			return color.RGBA{r, g, b, 255}
+		},
+	})
+}
+
+// wrapByte wraps v around into [0, 256) like an integer overflow would,
+// converting a float out of range for an uint8 is implementation defined
+func wrapByte(v float64) uint8 {
+	v = math.Mod(v, 256)
+
+	switch {
+	case math.IsNaN(v):
+		return 0
+	case v < 0:
+		v += 256
+	}
+
+	// Tiny negative values round up to 256 when wrapped
+	return uint8(math.Min(v, 255))
+}
+
+// luminance is the weighted average for human perception as per the GIMP docs:
+// https://docs.gimp.org/2.8/en/gimp-tool-desaturate.html
+func luminance(c color.RGBA) uint8 {
+	return uint8(float64(c.R)*0.21 + float64(c.G)*0.72 + float64(c.B)*0.07)
+}
+
+// ramp colors the pattern like the last image in the article,
+// mixing between a few colors by v, the length of q and k.X
+func ramp(v float64, q, k pixel.Vec) color.RGBA {
+	var (
+		f   = 0.5 + 0.5*v
+		col = mixVec3(
+			vec3{0.101961, 0.619608, 0.666667},
+			vec3{0.666667, 0.666667, 0.498039},
+			clamp(f*f*4, 0, 1),
+		)
+	)
+
+	col = mixVec3(col, vec3{0, 0, 0.164706}, clamp(q.Len(), 0, 1))
+	col = mixVec3(col, vec3{0.666667, 1, 1}, clamp(math.Abs(k.X), 0, 1))
+
+	l := f*f*f + 0.6*f*f + 0.5*f
+
+	return color.RGBA{
+		uint8(clamp(col[0]*l, 0, 1) * 255),
+		uint8(clamp(col[1]*l, 0, 1) * 255),
+		uint8(clamp(col[2]*l, 0, 1) * 255),
+		255,
+	}
+}
+
+// shade lights c using the normal of the pattern at p, found by
+// evaluating the pattern at two nearby points
+func shade(s *Scene, c color.RGBA, p pixel.Vec, v float64) color.RGBA {
+	const (
+		e        = 0.001
+		strength = 0.02
+	)
+
+	dx, _, _ := s.Eval(p.Add(pixel.V(e, 0)))
+	dy, _, _ := s.Eval(p.Add(pixel.V(0, e)))
+
+	var (
+		n     = normalize(vec3{-(dx - v) / e * strength, -(dy - v) / e * strength, 1})
+		light = normalize(vec3{-1, 1, 1})
+
+		diffuse = math.Max(0, n[0]*light[0]+n[1]*light[1]+n[2]*light[2])
+		l       = 0.3 + 0.9*diffuse
+	)
+
+	return color.RGBA{
+		uint8(clamp(float64(c.R)*l, 0, 255)),
+		uint8(clamp(float64(c.G)*l, 0, 255)),
+		uint8(clamp(float64(c.B)*l, 0, 255)),
+		255,
+	}
+}
+
+func mix(a, b color.RGBA, t float64) color.RGBA {
+	return color.RGBA{
+		uint8(float64(a.R) + (float64(b.R)-float64(a.R))*t),
+		uint8(float64(a.G) + (float64(b.G)-float64(a.G))*t),
+		uint8(float64(a.B) + (float64(b.B)-float64(a.B))*t),
+		uint8(float64(a.A) + (float64(b.A)-float64(a.A))*t),
+	}
+}
+
+type vec3 [3]float64
+
+func mixVec3(a, b vec3, t float64) vec3 {
+	return vec3{
+		a[0] + (b[0]-a[0])*t,
+		a[1] + (b[1]-a[1])*t,
+		a[2] + (b[2]-a[2])*t,
+	}
+}
+
+func normalize(v vec3) vec3 {
+	l := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+
+	return vec3{v[0] / l, v[1] / l, v[2] / l}
+}
+
+func smoothstep(e0, e1, x float64) float64 {
+	t := clamp((x-e0)/(e1-e0), 0, 1)
+
+	return t * t * (3 - 2*t)
+}
+
+func clamp(x, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, x))
+}
@@ -0,0 +1,99 @@
+package warp
+
+import (
+	"fmt"
+
+	pixel "github.com/faiface/pixel"
+)
+
+// PatternFunc returns the value of the pattern at p, along with the
+// intermediate warps q and k that the modes may use for coloring
+type PatternFunc func(s *Scene, p pixel.Vec) (v float64, q, k pixel.Vec)
+
+// Pattern is a named PatternFunc
+type Pattern struct {
+	Name        string
+	Description string
+	Func        PatternFunc
+}
+
+var patterns []Pattern
+
+// RegisterPattern makes a pattern available by name,
+// it panics if a pattern with the same name is already registered
+func RegisterPattern(p Pattern) {
+	if _, ok := LookupPattern(p.Name); ok {
+		panic(fmt.Sprintf("warp: pattern %q registered twice", p.Name))
+	}
+
+	patterns = append(patterns, p)
+}
+
+// Patterns returns all registered patterns in the order they were registered
+func Patterns() []Pattern {
+	return append([]Pattern(nil), patterns...)
+}
+
+// LookupPattern returns the pattern registered with the given name
+func LookupPattern(name string) (Pattern, bool) {
+	for _, p := range patterns {
+		if p.Name == name {
+			return p, true
+		}
+	}
+
+	return Pattern{}, false
+}
+
+func init() {
+	RegisterPattern(Pattern{
+		Name:        "warp",
+		Description: "Two levels of warping, k scaled by pos",
+		Func: func(s *Scene, p pixel.Vec) (float64, pixel.Vec, pixel.Vec) {
+			q := pixel.V(
+				s.Noise.FBM(p.Add(pixel.V(0.0, 0.0))),
+				s.Noise.FBM(p.Add(pixel.V(5.2, 1.3))),
+			)
+
+			k := pixel.V(
+				s.Noise.FBM(p.Add(q.Scaled(s.Pos.Y).Add(pixel.V(1.7, 9.2)))),
+				s.Noise.FBM(p.Add(q.Scaled(s.Pos.X).Add(pixel.V(8.3, 2.8)))),
+			)
+
+			v := s.Noise.FBM(p.Add(k.Scaled(s.Pos.Y * s.Pos.X)))
+
+			return v, q, k
+		},
+	})
+
+	RegisterPattern(Pattern{
+		Name:        "offset",
+		Description: "Two levels of warping, all scaled by pos.X, as in the variations",
+		Func: func(s *Scene, p pixel.Vec) (float64, pixel.Vec, pixel.Vec) {
+			q := pixel.V(
+				s.Noise.FBM(p.Add(pixel.V(0.0, 0.0))),
+				s.Noise.FBM(p.Add(pixel.V(5.2, 1.3))),
+			)
+
+			r := pixel.V(
+				s.Noise.FBM(p.Add(q.Scaled(s.Pos.X).Add(pixel.V(1.7, 9.2)))),
+				s.Noise.FBM(p.Add(q.Scaled(s.Pos.X).Add(pixel.V(8.3, 2.8)))),
+			)
+
+			return s.Noise.FBM(p.Add(r.Scaled(s.Pos.X))), q, r
+		},
+	})
+
+	RegisterPattern(Pattern{
+		Name:        "single",
+		Description: "A single level of warping, q scaled by pos.X",
+		Func: func(s *Scene, p pixel.Vec) (float64, pixel.Vec, pixel.Vec) {
+			q := pixel.V(
+				s.Noise.FBM(p.Add(pixel.V(0.0, 0.0))),
+				s.Noise.FBM(p.Add(pixel.V(5.2, 1.3))),
+			)
+
+			return s.Noise.FBM(p.Add(q.Scaled(s.Pos.X))), q, q
+		},
+	})
+}
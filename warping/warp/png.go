@@ -0,0 +1,325 @@
+package warp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// errNotStreamable is returned for PNG files that pngRows can not read
+// one row at a time, they have to be decoded as a whole instead
+var errNotStreamable = errors.New("warp: png can not be streamed")
+
+// pngRows reads a non-interlaced PNG one row at a time, so that the
+// whole image never has to be in memory. The pixels are converted to
+// exactly what decoding the image with image/png and drawing it onto
+// an *image.RGBA would give.
+type pngRows struct {
+	bounds    image.Rectangle
+	depth     int
+	colorType int
+	palette   [256]color.Color
+
+	z io.ReadCloser
+
+	// Bytes per pixel, rounded up to 1, and the current and previous
+	// rows, including the leading filter type byte
+	bpp       int
+	cur, prev []uint8
+}
+
+// newPNGRows reads the header of the PNG in r, up to the image data
+func newPNGRows(r *bufio.Reader) (*pngRows, error) {
+	sig := make([]byte, len(pngSignature))
+
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(sig, pngSignature) {
+		return nil, errNotStreamable
+	}
+
+	p := &pngRows{}
+
+	for i := range p.palette {
+		p.palette[i] = color.RGBA{0, 0, 0, 0xff}
+	}
+
+	var (
+		header [8]byte
+		trns   []byte
+	)
+
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+
+		length, typ := binary.BigEndian.Uint32(header[:4]), string(header[4:])
+
+		if typ == "IDAT" {
+			break
+		}
+
+		data := make([]byte, length+4) // Including the CRC
+
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+
+		data = data[:length]
+
+		switch typ {
+		case "IHDR":
+			if length != 13 {
+				return nil, fmt.Errorf("warp: bad png header length %d", length)
+			}
+
+			w, h := int32(binary.BigEndian.Uint32(data[0:4])), int32(binary.BigEndian.Uint32(data[4:8]))
+
+			// The same limits as image/png, where a pixel is at most 8 bytes
+			if n := int64(w) * int64(h); w <= 0 || h <= 0 || n != int64(int(n)) || int(n) != int(n)*8/8 {
+				return nil, fmt.Errorf("warp: bad png dimensions %dx%d", w, h)
+			}
+
+			if data[10] != 0 || data[11] != 0 {
+				return nil, fmt.Errorf("warp: bad png compression method %d or filter method %d", data[10], data[11])
+			}
+
+			p.bounds = image.Rect(0, 0, int(w), int(h))
+			p.depth, p.colorType = int(data[8]), int(data[9])
+
+			if data[12] != 0 {
+				return nil, errNotStreamable
+			}
+		case "PLTE":
+			for i := 0; i+2 < len(data) && i/3 < len(p.palette); i += 3 {
+				p.palette[i/3] = color.RGBA{data[i], data[i+1], data[i+2], 0xff}
+			}
+		case "tRNS":
+			trns = data
+		}
+	}
+
+	channels := map[int]int{0: 1, 2: 3, 3: 1, 4: 2, 6: 4}[p.colorType]
+
+	switch {
+	case channels == 0, p.bounds.Empty():
+		return nil, errNotStreamable
+	case p.depth != 8 && !(p.depth == 16 && p.colorType != 3) &&
+		!((p.depth == 1 || p.depth == 2 || p.depth == 4) && (p.colorType == 0 || p.colorType == 3)):
+		return nil, errNotStreamable
+	case trns != nil && p.colorType != 3:
+		// Transparency for gray and RGB images is rare enough
+		// to simply decode those images as a whole
+		return nil, errNotStreamable
+	}
+
+	for i, a := range trns {
+		if i < len(p.palette) {
+			c := p.palette[i].(color.RGBA)
+
+			p.palette[i] = color.NRGBA{c.R, c.G, c.B, a}
+		}
+	}
+
+	bits := channels * p.depth
+
+	p.bpp = (bits + 7) / 8
+	p.cur = make([]uint8, 1+(p.bounds.Dx()*bits+7)/8)
+	p.prev = make([]uint8, len(p.cur))
+
+	z, err := zlib.NewReader(&idatReader{r: r, remaining: binary.BigEndian.Uint32(header[:4])})
+	if err != nil {
+		return nil, err
+	}
+
+	p.z = z
+
+	return p, nil
+}
+
+// Bounds returns the bounds of the image
+func (p *pngRows) Bounds() image.Rectangle {
+	return p.bounds
+}
+
+// ReadRow reads the next row into dst as premultiplied RGBA
+func (p *pngRows) ReadRow(dst []uint8) error {
+	p.cur, p.prev = p.prev, p.cur
+
+	if _, err := io.ReadFull(p.z, p.cur); err != nil {
+		return err
+	}
+
+	if err := unfilter(p.cur[0], p.cur[1:], p.prev[1:], p.bpp); err != nil {
+		return err
+	}
+
+	for x := 0; x < p.bounds.Dx(); x++ {
+		r, g, b, a := p.at(x).RGBA()
+
+		dst[4*x+0] = uint8(r >> 8)
+		dst[4*x+1] = uint8(g >> 8)
+		dst[4*x+2] = uint8(b >> 8)
+		dst[4*x+3] = uint8(a >> 8)
+	}
+
+	return nil
+}
+
+// at returns the color of pixel x in the current row, as the
+// color type image/png would have decoded it into
+func (p *pngRows) at(x int) color.Color {
+	row := p.cur[1:]
+
+	if p.depth < 8 {
+		var (
+			perByte = 8 / p.depth
+			mask    = uint8(1<<p.depth - 1)
+			v       = row[x/perByte] >> uint(8-p.depth*(x%perByte+1)) & mask
+		)
+
+		if p.colorType == 3 {
+			return p.palette[v]
+		}
+
+		return color.Gray{v * (0xff / mask)}
+	}
+
+	if p.depth == 8 {
+		switch px := row[x*p.bpp:]; p.colorType {
+		case 0:
+			return color.Gray{px[0]}
+		case 2:
+			return color.RGBA{px[0], px[1], px[2], 0xff}
+		case 3:
+			return p.palette[px[0]]
+		case 4:
+			return color.NRGBA{px[0], px[0], px[0], px[1]}
+		default:
+			return color.NRGBA{px[0], px[1], px[2], px[3]}
+		}
+	}
+
+	px := row[x*p.bpp:]
+
+	v := func(i int) uint16 {
+		return binary.BigEndian.Uint16(px[2*i:])
+	}
+
+	switch p.colorType {
+	case 0:
+		return color.Gray16{v(0)}
+	case 2:
+		return color.RGBA64{v(0), v(1), v(2), 0xffff}
+	case 4:
+		return color.NRGBA64{v(0), v(0), v(0), v(1)}
+	default:
+		return color.NRGBA64{v(0), v(1), v(2), v(3)}
+	}
+}
+
+// unfilter reverses the PNG filter ft applied to cur,
+// where prev is the previous row after it was unfiltered
+func unfilter(ft uint8, cur, prev []uint8, bpp int) error {
+	switch ft {
+	case 0:
+	case 1:
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += cur[i-bpp]
+		}
+	case 2:
+		for i := range cur {
+			cur[i] += prev[i]
+		}
+	case 3:
+		for i := range cur {
+			var left int
+
+			if i >= bpp {
+				left = int(cur[i-bpp])
+			}
+
+			cur[i] += uint8((left + int(prev[i])) / 2)
+		}
+	case 4:
+		for i := range cur {
+			var a, c int
+
+			if i >= bpp {
+				a, c = int(cur[i-bpp]), int(prev[i-bpp])
+			}
+
+			cur[i] += paeth(a, int(prev[i]), c)
+		}
+	default:
+		return fmt.Errorf("warp: bad png filter type %d", ft)
+	}
+
+	return nil
+}
+
+func paeth(a, b, c int) uint8 {
+	p := a + b - c
+
+	pa, pb, pc := abs(p-a), abs(p-b), abs(p-c)
+
+	switch {
+	case pa <= pb && pa <= pc:
+		return uint8(a)
+	case pb <= pc:
+		return uint8(b)
+	default:
+		return uint8(c)
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+
+	return v
+}
+
+// idatReader reads the data of consecutive IDAT chunks as one stream
+type idatReader struct {
+	r         *bufio.Reader
+	remaining uint32
+}
+
+func (d *idatReader) Read(b []byte) (int, error) {
+	for d.remaining == 0 {
+		var header [12]byte // CRC of the previous chunk, length and type
+
+		if _, err := io.ReadFull(d.r, header[:]); err != nil {
+			return 0, err
+		}
+
+		if string(header[8:]) != "IDAT" {
+			return 0, io.EOF
+		}
+
+		d.remaining = binary.BigEndian.Uint32(header[4:8])
+	}
+
+	if uint32(len(b)) > d.remaining {
+		b = b[:d.remaining]
+	}
+
+	n, err := d.r.Read(b)
+
+	d.remaining -= uint32(n)
+
+	return n, err
+}
@@ -0,0 +1,260 @@
+package warp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pngChunk appends a chunk of type typ with its length and CRC to b
+func pngChunk(b []byte, typ string, data []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	b = append(b, typ...)
+	b = append(b, data...)
+
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(append([]byte(typ), data...)))
+}
+
+// pngHeader returns the signature and an IHDR chunk
+func pngHeader(w, h uint32, depth, colorType uint8) []byte {
+	ihdr := binary.BigEndian.AppendUint32(nil, w)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, h)
+	ihdr = append(ihdr, depth, colorType, 0, 0, 0)
+
+	return pngChunk(append([]byte(nil), pngSignature...), "IHDR", ihdr)
+}
+
+// encodeRaw encodes the raw rows as a PNG, row y is filtered with
+// filter type y%5 and the image data is split into idats chunks
+func encodeRaw(t *testing.T, w, h int, depth, colorType uint8, bpp int, plte, trns []byte, raw [][]byte, idats int) []byte {
+	b := pngHeader(uint32(w), uint32(h), depth, colorType)
+
+	if plte != nil {
+		b = pngChunk(b, "PLTE", plte)
+	}
+
+	if trns != nil {
+		b = pngChunk(b, "tRNS", trns)
+	}
+
+	var buf bytes.Buffer
+
+	z := zlib.NewWriter(&buf)
+
+	prev := make([]byte, len(raw[0]))
+
+	for y, cur := range raw {
+		ft := uint8(y % 5)
+
+		z.Write(append([]byte{ft}, filter(ft, cur, prev, bpp)...))
+
+		prev = cur
+	}
+
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	size := (len(data) + idats - 1) / idats
+
+	for len(data) > 0 {
+		n := size
+
+		if n > len(data) {
+			n = len(data)
+		}
+
+		b = pngChunk(b, "IDAT", data[:n])
+		data = data[n:]
+	}
+
+	return pngChunk(b, "IEND", nil)
+}
+
+// filter applies filter type ft to cur, the reverse of unfilter
+func filter(ft uint8, cur, prev []byte, bpp int) []byte {
+	out := make([]byte, len(cur))
+
+	for i := range cur {
+		var a, c int
+
+		if i >= bpp {
+			a, c = int(cur[i-bpp]), int(prev[i-bpp])
+		}
+
+		switch ft {
+		case 0:
+			out[i] = cur[i]
+		case 1:
+			out[i] = cur[i] - uint8(a)
+		case 2:
+			out[i] = cur[i] - prev[i]
+		case 3:
+			out[i] = cur[i] - uint8((a+int(prev[i]))/2)
+		case 4:
+			out[i] = cur[i] - paeth(a, int(prev[i]), c)
+		}
+	}
+
+	return out
+}
+
+func TestPNGRows(t *testing.T) {
+	const w, h = 13, 15
+
+	for _, tc := range []struct {
+		name      string
+		depth     uint8
+		colorType uint8
+		channels  int
+		colors    int
+		trns      int
+	}{
+		{"gray1", 1, 0, 1, 0, 0},
+		{"gray2", 2, 0, 1, 0, 0},
+		{"gray4", 4, 0, 1, 0, 0},
+		{"gray8", 8, 0, 1, 0, 0},
+		{"gray16", 16, 0, 1, 0, 0},
+		{"rgb8", 8, 2, 3, 0, 0},
+		{"rgb16", 16, 2, 3, 0, 0},
+		{"paletted1", 1, 3, 1, 2, 1},
+		{"paletted2", 2, 3, 1, 4, 3},
+		{"paletted4", 4, 3, 1, 16, 5},
+		{"paletted8", 8, 3, 1, 200, 100},
+		{"gray-alpha8", 8, 4, 2, 0, 0},
+		{"gray-alpha16", 16, 4, 2, 0, 0},
+		{"rgba8", 8, 6, 4, 0, 0},
+		{"rgba16", 16, 6, 4, 0, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				r    = rand.New(rand.NewSource(1))
+				bits = tc.channels * int(tc.depth)
+				bpp  = (bits + 7) / 8
+				raw  = make([][]byte, h)
+
+				plte, trns []byte
+			)
+
+			// Random rows, the paletted8 indices also go past the palette
+			for y := range raw {
+				raw[y] = make([]byte, (w*bits+7)/8)
+
+				r.Read(raw[y])
+			}
+
+			if tc.colors > 0 {
+				plte = make([]byte, 3*tc.colors)
+				trns = make([]byte, tc.trns)
+
+				r.Read(plte)
+				r.Read(trns)
+			}
+
+			b := encodeRaw(t, w, h, tc.depth, tc.colorType, bpp, plte, trns, raw, 3)
+
+			m, err := png.Decode(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := image.NewRGBA(m.Bounds())
+
+			draw.Draw(want, want.Rect, m, image.Point{}, draw.Src)
+
+			p, err := newPNGRows(bufio.NewReader(bytes.NewReader(b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p.Bounds() != want.Rect {
+				t.Fatalf("bounds %v, want %v", p.Bounds(), want.Rect)
+			}
+
+			row := make([]uint8, 4*w)
+
+			for y := 0; y < h; y++ {
+				if err := p.ReadRow(row); err != nil {
+					t.Fatalf("row %d: %v", y, err)
+				}
+
+				if got, want := row, want.Pix[want.PixOffset(0, y):][:4*w]; !bytes.Equal(got, want) {
+					t.Fatalf("row %d with filter %d:\n got %v\nwant %v", y, y%5, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPNGRowsBadDimensions(t *testing.T) {
+	for _, d := range [][2]uint32{
+		{0, 1},
+		{1, 0},
+		{0x80000000, 1},
+		{1, 0xffffffff},
+		{0x7fffffff, 0x7fffffff},
+	} {
+		// Image data follows, so that only the dimensions are wrong
+		b := pngChunk(pngChunk(pngHeader(d[0], d[1], 8, 6), "IDAT", []byte{0x78, 0x9c, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01}), "IEND", nil)
+
+		if _, err := png.DecodeConfig(bytes.NewReader(b)); err == nil {
+			t.Errorf("%dx%d: image/png accepts the dimensions", d[0], d[1])
+		}
+
+		_, err := newPNGRows(bufio.NewReader(bytes.NewReader(b)))
+		if err == nil || err == errNotStreamable {
+			t.Errorf("%dx%d: got error %v, want the dimensions rejected", d[0], d[1], err)
+		}
+	}
+}
+
+func TestOpenTilesStreamed(t *testing.T) {
+	var (
+		dir = t.TempDir()
+		m   = XorImage(40, 30)
+	)
+
+	for _, tc := range []struct {
+		fn       string
+		encode   func(f *os.File) error
+		streamed bool
+	}{
+		{"xor.png", func(f *os.File) error { return png.Encode(f, m) }, true},
+		{"xor.jpg", func(f *os.File) error { return jpeg.Encode(f, m, nil) }, false},
+	} {
+		fn := filepath.Join(dir, tc.fn)
+
+		f, err := os.Create(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := tc.encode(f); err != nil {
+			t.Fatal(err)
+		}
+
+		f.Close()
+
+		tiles, err := OpenTiles(fn, 16, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tiles.Streamed() != tc.streamed {
+			t.Errorf("%s: streamed %v, want %v", tc.fn, tiles.Streamed(), tc.streamed)
+		}
+
+		tiles.Close()
+	}
+}
@@ -0,0 +1,397 @@
+package warp
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"sync"
+)
+
+// Tiles is an image split into square tiles stored in a temporary
+// file, so that only the tiles being read need to be in memory.
+//
+// Each tile overlaps its neighbours by overlap pixels, the warp
+// mostly samples close to where it sampled last, so the overlap
+// lets it keep reading the same tile when crossing an edge.
+type Tiles struct {
+	f *os.File
+
+	bounds  image.Rectangle
+	size    int
+	overlap int
+	stride  int
+	cols    int
+
+	streamed bool
+}
+
+// NewTiles writes m into a temporary file as tiles of size by size pixels
+func NewTiles(m image.Image, size, overlap int) (*Tiles, error) {
+	return newTiles(&imageRows{m: m, y: m.Bounds().Min.Y}, size, overlap)
+}
+
+// OpenTiles writes the image in fn into a temporary file as tiles of
+// size by size pixels. PNG images are read one row at a time, so only
+// a row of tiles is ever in memory, other images are decoded as a whole.
+func OpenTiles(fn string, size, overlap int) (*Tiles, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := newPNGRows(bufio.NewReader(f))
+	if err == nil {
+		t, err := newTiles(p, size, overlap)
+		if err != nil {
+			return nil, err
+		}
+
+		t.streamed = true
+
+		return t, nil
+	}
+
+	if _, serr := f.Seek(0, io.SeekStart); serr != nil {
+		return nil, serr
+	}
+
+	m, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTiles(m, size, overlap)
+}
+
+// Streamed reports whether the image was read one row at a time,
+// rather than decoded as a whole before it was split into tiles
+func (t *Tiles) Streamed() bool {
+	return t.streamed
+}
+
+// rows is an image that is read one row at a time, from the top
+type rows interface {
+	Bounds() image.Rectangle
+
+	// ReadRow reads the next row into dst as RGBA
+	ReadRow(dst []uint8) error
+}
+
+// imageRows reads the rows of an image that is already in memory
+type imageRows struct {
+	m image.Image
+	y int
+}
+
+func (r *imageRows) Bounds() image.Rectangle {
+	return r.m.Bounds()
+}
+
+func (r *imageRows) ReadRow(dst []uint8) error {
+	b := r.m.Bounds()
+
+	row := &image.RGBA{Pix: dst, Stride: len(dst), Rect: image.Rect(b.Min.X, r.y, b.Max.X, r.y+1)}
+
+	draw.Draw(row, row.Rect, r.m, row.Rect.Min, draw.Src)
+
+	r.y++
+
+	return nil
+}
+
+// newTiles reads src into tiles, a row of tiles at a time
+func newTiles(src rows, size, overlap int) (*Tiles, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("warp: tile size must be positive, not %d", size)
+	}
+
+	if overlap < 0 {
+		return nil, fmt.Errorf("warp: tile overlap can not be negative, not %d", overlap)
+	}
+
+	f, err := os.CreateTemp("", "warp-tiles-")
+	if err != nil {
+		return nil, err
+	}
+
+	b := src.Bounds()
+
+	t := &Tiles{
+		f:       f,
+		bounds:  b,
+		size:    size,
+		overlap: overlap,
+		stride:  size + 2*overlap,
+		cols:    (b.Dx() + size - 1) / size,
+	}
+
+	rows := (b.Dy() + size - 1) / size
+
+	// The band holds the source rows covered by a row of tiles, overlap
+	// included, anything outside of the image is left transparent
+	band := image.NewRGBA(image.Rect(
+		b.Min.X-overlap, b.Min.Y-overlap,
+		b.Min.X+t.cols*size+overlap, b.Min.Y-overlap+t.stride,
+	))
+
+	buf := image.NewRGBA(image.Rect(0, 0, t.stride, t.stride))
+
+	next := b.Min.Y
+
+	for ty := 0; ty < rows; ty++ {
+		if ty > 0 {
+			// Keep the rows shared with the previous row of tiles
+			copy(band.Pix, band.Pix[size*band.Stride:])
+
+			for i := (t.stride - size) * band.Stride; i < len(band.Pix); i++ {
+				band.Pix[i] = 0
+			}
+
+			band.Rect = band.Rect.Add(image.Pt(0, size))
+		}
+
+		for ; next < b.Max.Y && next < band.Rect.Max.Y; next++ {
+			i := band.PixOffset(b.Min.X, next)
+
+			if err := src.ReadRow(band.Pix[i : i+4*b.Dx()]); err != nil {
+				t.Close()
+
+				return nil, err
+			}
+		}
+
+		for tx := 0; tx < t.cols; tx++ {
+			r := t.rect(tx, ty)
+
+			draw.Draw(buf, buf.Bounds(), band, r.Min, draw.Src)
+
+			if _, err := f.WriteAt(buf.Pix, t.offset(tx, ty)); err != nil {
+				t.Close()
+
+				return nil, err
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// Bounds returns the bounds of the whole image
+func (t *Tiles) Bounds() image.Rectangle {
+	return t.bounds
+}
+
+// Close removes the temporary file
+func (t *Tiles) Close() error {
+	t.f.Close()
+
+	return os.Remove(t.f.Name())
+}
+
+// rect returns the area covered by tile tx, ty including the overlap
+func (t *Tiles) rect(tx, ty int) image.Rectangle {
+	min := t.bounds.Min.Add(image.Pt(tx*t.size-t.overlap, ty*t.size-t.overlap))
+
+	return image.Rectangle{min, min.Add(image.Pt(t.stride, t.stride))}
+}
+
+func (t *Tiles) offset(tx, ty int) int64 {
+	return int64(ty*t.cols+tx) * int64(t.stride*t.stride*4)
+}
+
+// Reader returns a reader that keeps up to cache tiles in memory.
+// Readers are not safe for concurrent use, but several readers
+// can read the same tiles at once.
+func (t *Tiles) Reader(cache int) *TileReader {
+	return &TileReader{t: t, cache: make([]*image.RGBA, 0, max(cache, 1))}
+}
+
+// TileReader reads pixels from Tiles, it implements Image
+type TileReader struct {
+	t *Tiles
+
+	// Most recently used tile first
+	cache []*image.RGBA
+
+	err error
+}
+
+// Bounds returns the bounds of the whole image
+func (r *TileReader) Bounds() image.Rectangle {
+	return r.t.bounds
+}
+
+// RGBAAt returns the color at x, y, reading its tile if it is not cached
+func (r *TileReader) RGBAAt(x, y int) color.RGBA {
+	p := image.Pt(x, y)
+
+	if !p.In(r.t.bounds) {
+		return color.RGBA{}
+	}
+
+	for i, m := range r.cache {
+		if p.In(m.Rect) {
+			copy(r.cache[1:i+1], r.cache[:i])
+			r.cache[0] = m
+
+			return m.RGBAAt(x, y)
+		}
+	}
+
+	q := p.Sub(r.t.bounds.Min)
+
+	m, err := r.read(q.X/r.t.size, q.Y/r.t.size)
+	if err != nil {
+		r.err = err
+
+		return color.RGBA{}
+	}
+
+	if len(r.cache) < cap(r.cache) {
+		r.cache = append(r.cache, nil)
+	}
+
+	copy(r.cache[1:], r.cache)
+	r.cache[0] = m
+
+	return m.RGBAAt(x, y)
+}
+
+// Err returns the first error encountered while reading a tile
+func (r *TileReader) Err() error {
+	return r.err
+}
+
+func (r *TileReader) read(tx, ty int) (*image.RGBA, error) {
+	m := image.NewRGBA(r.t.rect(tx, ty))
+
+	if _, err := r.t.f.ReadAt(m.Pix, r.t.offset(tx, ty)); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// EncodeTiled renders the scene, with the source read from tiles,
+// and encodes it as a PNG to w. The output is rendered one row of
+// tiles at a time, as the PNG encoder asks for it, so only that row,
+// a few cached source tiles per worker and the blend image of the
+// scene are held in memory while encoding.
+func EncodeTiled(w io.Writer, s Scene, t *Tiles, workers int) error {
+	m := &stripImage{
+		scene:   s,
+		tiles:   t,
+		readers: make([]*TileReader, max(workers, 1)),
+		strip:   &image.RGBA{},
+	}
+
+	for i := range m.readers {
+		m.readers[i] = t.Reader(4)
+	}
+
+	if err := png.Encode(w, m); err != nil {
+		return err
+	}
+
+	for _, r := range m.readers {
+		if err := r.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stripImage is an image.Image that renders a row of tiles
+// whenever a pixel outside the current row is asked for
+type stripImage struct {
+	scene   Scene
+	tiles   *Tiles
+	readers []*TileReader
+	strip   *image.RGBA
+}
+
+func (m *stripImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+func (m *stripImage) Bounds() image.Rectangle {
+	return m.tiles.Bounds()
+}
+
+// Opaque keeps the PNG encoder from looking at every pixel up front
+func (m *stripImage) Opaque() bool {
+	return false
+}
+
+func (m *stripImage) At(x, y int) color.Color {
+	if !image.Pt(x, y).In(m.strip.Rect) {
+		m.render(y)
+	}
+
+	return m.strip.RGBAAt(x, y)
+}
+
+// render renders the row of tiles containing y, each worker
+// rendering whole tiles using a scene with its own reader
+func (m *stripImage) render(y int) {
+	var (
+		b    = m.tiles.Bounds()
+		size = m.tiles.size
+		y0   = b.Min.Y + (y-b.Min.Y)/size*size
+		y1   = y0 + size
+	)
+
+	if y1 > b.Max.Y {
+		y1 = b.Max.Y
+	}
+
+	if r := image.Rect(b.Min.X, y0, b.Max.X, y1); r.Size() == m.strip.Rect.Size() {
+		m.strip.Rect = r
+	} else {
+		m.strip = image.NewRGBA(r)
+	}
+
+	xs := make(chan int, m.tiles.cols)
+
+	for x := b.Min.X; x < b.Max.X; x += size {
+		xs <- x
+	}
+
+	close(xs)
+
+	var wg sync.WaitGroup
+
+	for _, r := range m.readers {
+		wg.Add(1)
+
+		go func(s Scene) {
+			defer wg.Done()
+
+			for x0 := range xs {
+				for y := y0; y < y1; y++ {
+					i := m.strip.PixOffset(x0, y)
+
+					for x := x0; x < x0+size && x < b.Max.X; x++ {
+						c := s.Color(x, y)
+
+						m.strip.Pix[i+0] = c.R
+						m.strip.Pix[i+1] = c.G
+						m.strip.Pix[i+2] = c.B
+						m.strip.Pix[i+3] = c.A
+
+						i += 4
+					}
+				}
+			}
+		}(m.scene.WithSource(r))
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,186 @@
+// Package warp contains the noise, patterns and coloring modes used by
+// the domain warping experiment. Patterns and modes are registered by
+// name, so new ones can be added without copying the whole program.
+//
+// Inspired by this article
+// http://www.iquilezles.org/www/articles/warp/warp.htm
+package warp
+
+import (
+	"image"
+	"image/color"
+
+	pixel "github.com/faiface/pixel"
+	opensimplex "github.com/ojrac/opensimplex-go"
+)
+
+// Noise is fractal Brownian motion on top of opensimplex noise
+type Noise struct {
+	*opensimplex.Noise
+
+	Octaves    int
+	Lacunarity float64
+	Gain       float64
+}
+
+// NewNoise returns noise with the given seed and the default fbm settings
+func NewNoise(seed int64) Noise {
+	return Noise{
+		Noise:      opensimplex.NewWithSeed(seed),
+		Octaves:    4,
+		Lacunarity: 2.02,
+		Gain:       0.5,
+	}
+}
+
+// FBM returns octaves of noise where each octave is rotated, has its
+// frequency multiplied by lacunarity and its amplitude multiplied by
+// gain, normalized back into [-1, 1]
+func (n Noise) FBM(p pixel.Vec) float64 {
+	var (
+		sum  = 0.0
+		amp  = 1.0
+		norm = 0.0
+	)
+
+	for i := 0; i < max(n.Octaves, 1); i++ {
+		sum += amp * n.Eval2(p.X, p.Y)
+		norm += amp
+
+		// Rotate to reduce axial bias, using the same matrix as the article
+		p = pixel.V(0.8*p.X-0.6*p.Y, 0.6*p.X+0.8*p.Y).Scaled(n.Lacunarity)
+
+		amp *= n.Gain
+	}
+
+	return sum / norm
+}
+
+// Image is an image whose pixels can be read without going through
+// color.Color, it is implemented by *image.RGBA and *TileReader
+type Image interface {
+	Bounds() image.Rectangle
+	RGBAAt(x, y int) color.RGBA
+}
+
+// Scene is everything needed to color a frame. It is only read while
+// rendering, so a copy can be used by several workers at once.
+type Scene struct {
+	Source Image
+	Blend  Image
+
+	Noise   Noise
+	Pattern Pattern
+	Mode    Mode
+
+	Pos pixel.Vec
+
+	// Field, when not nil, displaces each pixel before it is warped
+	Field *Field
+}
+
+// Sample is the pattern evaluated for a single pixel
+type Sample struct {
+	X, Y   int        // Pixel being colored
+	WX, WY int        // Pixel warped by the pattern
+	C      color.RGBA // Source color at WX, WY
+
+	P    pixel.Vec // Point the pattern was evaluated at
+	V    float64
+	Q, K pixel.Vec
+}
+
+// Color returns the color of pixel x, y
+func (s *Scene) Color(x, y int) color.RGBA {
+	fx, fy := float64(x), float64(y)
+
+	if s.Field != nil {
+		d := s.Field.At(fx, fy)
+
+		fx, fy = fx+d.X, fy+d.Y
+	}
+
+	p := pixel.V(fx*0.00123, fy*0.00321)
+
+	v, q, k := s.Eval(p)
+
+	wx, wy := s.Warp(fx, fy, k)
+
+	return s.Mode.Func(s, Sample{
+		X: x, Y: y,
+		WX: wx, WY: wy,
+		C: s.SourceAt(wx, wy),
+		P: p, V: v, Q: q, K: k,
+	})
+}
+
+// Eval evaluates the pattern of the scene at p
+func (s *Scene) Eval(p pixel.Vec) (float64, pixel.Vec, pixel.Vec) {
+	return s.Pattern.Func(s, p)
+}
+
+// Warp returns the source pixel that fx, fy is warped to by k
+func (s *Scene) Warp(fx, fy float64, k pixel.Vec) (int, int) {
+	w, h := s.Source.Bounds().Dx(), s.Source.Bounds().Dy()
+
+	wx, wy := int(fx*k.X)%w, int(fy*k.Y)%h
+
+	if wx < 0 || wx > w {
+		wx = int(fx - k.X)
+	}
+
+	if wy < 0 || wy > h {
+		wy = int(fy - k.Y)
+	}
+
+	return wx, wy
+}
+
+// WithSource returns a copy of the scene using another source
+func (s Scene) WithSource(m Image) Scene {
+	s.Source = m
+
+	return s
+}
+
+// SourceAt returns the color of the source at x, y, reading
+// the pixels of an *image.RGBA directly since it is the common case
+func (s *Scene) SourceAt(x, y int) color.RGBA {
+	m, ok := s.Source.(*image.RGBA)
+	if !ok {
+		return s.Source.RGBAAt(x, y)
+	}
+
+	if !(image.Point{x, y}.In(m.Rect)) {
+		return color.RGBA{}
+	}
+
+	i := m.PixOffset(x, y)
+
+	return color.RGBA{m.Pix[i], m.Pix[i+1], m.Pix[i+2], m.Pix[i+3]}
+}
+
+// BlendAt returns the color of the blend image, tiled to cover the source
+func (s *Scene) BlendAt(x, y int) color.RGBA {
+	b := s.Blend.Bounds()
+
+	x = b.Min.X + ((x%b.Dx())+b.Dx())%b.Dx()
+	y = b.Min.Y + ((y%b.Dy())+b.Dy())%b.Dy()
+
+	m, ok := s.Blend.(*image.RGBA)
+	if !ok {
+		return s.Blend.RGBAAt(x, y)
+	}
+
+	i := m.PixOffset(x, y)
+
+	return color.RGBA{m.Pix[i], m.Pix[i+1], m.Pix[i+2], m.Pix[i+3]}
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -4,24 +4,35 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"image"
-	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"time"
 
-	_ "image/jpeg"
-	_ "image/png"
-
 	pixel "github.com/faiface/pixel"
 	pixelgl "github.com/faiface/pixel/pixelgl"
 	opensimplex "github.com/ojrac/opensimplex-go"
 	zerolog "github.com/rs/zerolog"
 	log "github.com/rs/zerolog/log"
+
+	"github.com/peterhellberg/pixel-experiments/warping/warp"
 )
 
 var (
-	mode   int
+	mode    warp.Mode
+	pattern warp.Pattern
+
 	scale  float64
 	expand bool
 
@@ -30,16 +41,42 @@ var (
 
 	pos pixel.Vec
 
-	input chan pixelgl.Button
-
 	delay time.Duration
 	start time.Time
 
-	noise *opensimplex.Noise
+	workers int
+
+	noise warp.Noise
+	seed  int64
+
+	// frame is the scene that the target was last rendered from
+	frame warp.Scene
+
+	// field is displaced by the mouse brush, only used in the window
+	field *warp.Field
+	brush warp.Brush
+
+	brushRadius   = 40.0
+	brushStrength = 1.0
+	brushDecay    = 0.95
 
 	source *image.RGBA
 	target *image.RGBA
 
+	// blend is the second image used by the blend mode
+	blend *image.RGBA
+
+	imagePath string
+	blendPath string
+	videoPath string
+
+	// video replaces the source with a new frame on every update
+	video   warp.Frames
+	sources chan *image.RGBA
+
+	// videoFrame is the number of the video frame in source
+	videoFrame int
+
 	bounds pixel.Rect
 	matrix pixel.Matrix
 
@@ -50,64 +87,176 @@ func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
 	var (
-		fn   string
-		seed int64
-		posX float64
-		posY float64
-		d    time.Duration
+		fn     string
+		fn2    string
+		mn     string
+		pn     string
+		sf     string
+		vf     string
+		list   bool
+		posX   float64
+		posY   float64
+		d      time.Duration
+		dir    string
+		frames int
+		anim   string
+		tiled  string
+		tile   int
+		margin int
 	)
 
 	flag.StringVar(&fn, "image", "", "image")
+	flag.StringVar(&vf, "video", "", "directory of frames, or - for an MJPEG stream on stdin, used instead of -image")
+	flag.StringVar(&fn2, "blend", "", "second image, blended with the first in the blend mode")
 	flag.Float64Var(&scale, "scale", 1, "scale")
 	flag.Float64Var(&posX, "x", -2.07, "pos.X")
 	flag.Float64Var(&posY, "y", 0.257, "pos.Y")
 	flag.Int64Var(&seed, "seed", 1, "seed")
-	flag.IntVar(&mode, "mode", 1, "mode")
+	flag.StringVar(&mn, "mode", "1", "mode, by name or number")
+	flag.StringVar(&pn, "pattern", "warp", "pattern, by name")
+	flag.BoolVar(&list, "list", false, "list the available patterns and modes")
+	flag.StringVar(&sf, "state", "", "reload the configuration from a JSON file saved with C")
 	flag.DurationVar(&delay, "delay", 100*time.Millisecond, "delay")
+	flag.IntVar(&workers, "workers", runtime.NumCPU(), "number of render workers")
+	flag.IntVar(&noise.Octaves, "octaves", 4, "fbm octaves")
+	flag.Float64Var(&noise.Lacunarity, "lacunarity", 2.02, "fbm frequency multiplier per octave")
+	flag.Float64Var(&noise.Gain, "gain", 0.5, "fbm amplitude multiplier per octave")
+	flag.StringVar(&dir, "render", "", "render frames as PNG into this directory, without a window")
+	flag.IntVar(&frames, "frames", 100, "number of frames to render")
+	flag.StringVar(&anim, "gif", "", "also render the frames into this animated GIF")
+	flag.StringVar(&tiled, "tiled", "", "render a single frame of a large image into this PNG, tile by tile")
+	flag.IntVar(&tile, "tile", 256, "tile size used by -tiled")
+	flag.IntVar(&margin, "overlap", 16, "number of pixels each tile overlaps its neighbours by in -tiled")
 
 	flag.Parse()
 
+	if list {
+		printList()
+
+		return
+	}
+
 	if d <= 0 {
 		d = 1 * time.Millisecond
 	}
 
-	if err := setup(fn, pixel.V(posX, posY), seed); err != nil {
+	var skip int
+
+	if sf != "" {
+		// Fields missing from the state keep the values of the flags
+		s, err := loadState(sf, State{
+			Mode:       mn,
+			Pattern:    pn,
+			Pos:        pixel.V(posX, posY),
+			Seed:       seed,
+			Scale:      scale,
+			Image:      fn,
+			Blend:      fn2,
+			Video:      vf,
+			Octaves:    noise.Octaves,
+			Lacunarity: noise.Lacunarity,
+			Gain:       noise.Gain,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("state")
+		}
+
+		fn, fn2, vf, mn, pn = s.Image, s.Blend, s.Video, s.Mode, s.Pattern
+		posX, posY = s.Pos.X, s.Pos.Y
+		seed, scale, expand = s.Seed, s.Scale, s.Expand
+		skip, field = s.Frame, s.Field
+
+		noise.Octaves, noise.Lacunarity, noise.Gain = s.Octaves, s.Lacunarity, s.Gain
+	}
+
+	clampNoise()
+
+	if err := selectPattern(pn); err != nil {
+		log.Fatal().Err(err).Msg("pattern")
+	}
+
+	if err := selectMode(mn); err != nil {
+		log.Fatal().Err(err).Msg("mode")
+	}
+
+	if tiled != "" {
+		if err := renderTiled(fn, fn2, tiled, pixel.V(posX, posY), tile, margin); err != nil {
+			log.Fatal().Err(err).Msg("tiled")
+		}
+
+		return
+	}
+
+	if vf != "" {
+		v, err := warp.OpenFrames(vf)
+		if err != nil {
+			log.Fatal().Err(err).Msg("video")
+		}
+
+		if err := warp.Skip(v, skip); err != nil {
+			log.Fatal().Err(err).Msg("video")
+		}
+
+		video, videoPath, videoFrame = v, absPath(vf), skip
+	}
+
+	if err := setup(fn, fn2, pixel.V(posX, posY)); err != nil {
 		log.Fatal().Err(err).Msg("setup")
 	}
 
+	if dir != "" {
+		if err := render(dir, frames, anim); err != nil {
+			log.Fatal().Err(err).Msg("render")
+		}
+
+		return
+	}
+
 	pixelgl.Run(run)
 }
 
-func setup(fn string, p pixel.Vec, seed int64) error {
-	m, err := loadImage(fn)
-	if err != nil {
-		m = xorImage(256, 256)
+func setup(fn, fn2 string, p pixel.Vec) error {
+	var (
+		m   *image.RGBA
+		err error
+	)
+
+	if video != nil {
+		if m, err = video.Next(); err != nil {
+			return err
+		}
+
+		sources = make(chan *image.RGBA, 1)
+
+		go readFrames()
+	} else if m, err = warp.LoadImage(fn); err != nil {
+		m = warp.XorImage(256, 256)
 	}
 
 	w, h = m.Bounds().Dx(), m.Bounds().Dy()
 
 	fw, fh = float64(w), float64(h)
 
+	if fn2 == "" {
+		blend = warp.XorImage(w, h)
+	} else if blend, err = warp.LoadImage(fn2); err != nil {
+		return err
+	}
+
+	imagePath, blendPath = absPath(fn), absPath(fn2)
+
 	source = m
 	target = image.NewRGBA(source.Bounds())
 	bounds = pixel.R(0, 0, fw, fh)
 	matrix = flipY.Moved(bounds.Center()).Scaled(pixel.ZV, scale)
 
-	input = make(chan pixelgl.Button, 1024)
-
 	pos = p
 
-	noise = opensimplex.NewWithSeed(seed)
+	noise.Noise = opensimplex.NewWithSeed(seed)
 
 	return nil
 }
 
-func background(ticker *time.Ticker) {
-	for range ticker.C {
-		logState()
-	}
-}
-
 func run() {
 	start = time.Now()
 
@@ -123,23 +272,64 @@ func run() {
 	ticker := time.NewTicker(delay)
 	defer ticker.Stop()
 
-	go background(ticker)
-	go handleInput()
-
 	canvas := pixelgl.NewCanvas(bounds)
 
+	// The field may have been loaded along with the state
+	if field == nil {
+		field = warp.NewField(w, h, 4)
+	}
+
 	tickRate := 1.0 / 30
 	adt := 0.0
 
 	last := time.Now()
+	mouse := mousePosition(win)
 	for !win.Closed() {
 		adt += time.Since(last).Seconds()
 		last = time.Now()
 
 		processInput(win)
 
+		// Log the state every delay, on the same goroutine
+		// that changes it, so that nothing is read while written
+		select {
+		case <-ticker.C:
+			logState()
+		default:
+		}
+
+		m := mousePosition(win)
+
+		if win.Pressed(pixelgl.MouseButtonLeft) {
+			field.Apply(brush, m, m.Sub(mouse), brushRadius, brushStrength)
+		}
+
+		mouse = m
+
+		if win.JustPressed(pixelgl.KeyB) {
+			brush = brush.Next()
+
+			log.Info().Str("brush", brush.String()).Msg("Brush")
+		}
+
+		if win.JustPressed(pixelgl.KeyBackspace) {
+			field.Clear()
+		}
+
+		if s := win.MouseScroll().Y; s != 0 {
+			brushRadius = math.Max(5, brushRadius+s*5)
+		}
+
+		if win.JustPressed(pixelgl.KeyC) {
+			if err := capture(); err != nil {
+				log.Error().Err(err).Msg("capture")
+			}
+		}
+
 		if adt >= tickRate {
 			adt -= tickRate
+			field.Decay(brushDecay)
+			nextSource()
 			update()
 		}
 
@@ -150,7 +340,166 @@ func run() {
 	}
 }
 
+// render calls update once per tick, just like run does at the
+// tickRate, and writes each resulting target to a numbered PNG
+func render(dir string, frames int, anim string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var g gif.GIF
+
+	for i := 0; i < frames; i++ {
+		// Wait for the next video frame, rather than skipping ahead
+		if video != nil && i > 0 {
+			if m, ok := <-sources; ok {
+				source = m
+				videoFrame++
+			}
+		}
+
+		update()
+
+		fn := filepath.Join(dir, fmt.Sprintf("frame-%05d.png", i))
+
+		if err := savePNG(fn, target); err != nil {
+			return err
+		}
+
+		if anim != "" {
+			p := image.NewPaletted(target.Bounds(), palette.Plan9)
+
+			draw.FloydSteinberg.Draw(p, p.Bounds(), target, image.ZP)
+
+			g.Image = append(g.Image, p)
+			g.Delay = append(g.Delay, 3)
+		}
+
+		log.Info().Str("fn", fn).Int("frame", i).Msg("Rendered")
+	}
+
+	if anim == "" {
+		return nil
+	}
+
+	f, err := os.Create(anim)
+	if err != nil {
+		return err
+	}
+
+	if err := gif.EncodeAll(f, &g); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// renderTiled renders a single frame of an image that may be too large
+// to warp in memory. The decoded image is written to disk as tiles, and
+// the output is rendered and encoded a row of tiles at a time.
+func renderTiled(fn, fn2, out string, p pixel.Vec, size, overlap int) error {
+	tiles, err := warp.OpenTiles(fn, size, overlap)
+	if err != nil {
+		return err
+	}
+	defer tiles.Close()
+
+	if !tiles.Streamed() {
+		log.Warn().Str("fn", fn).Msg("Decoded as a whole, only non-interlaced PNG images are streamed")
+	}
+
+	// The same XOR pattern as setup uses, without storing all of it
+	var b warp.Image = warp.Xor{W: tiles.Bounds().Dx(), H: tiles.Bounds().Dy()}
+
+	if fn2 != "" {
+		if b, err = warp.LoadImage(fn2); err != nil {
+			return err
+		}
+	}
+
+	noise.Noise = opensimplex.NewWithSeed(seed)
+
+	o, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+
+	scene := warp.Scene{
+		Blend:   b,
+		Noise:   noise,
+		Pattern: pattern,
+		Mode:    mode,
+		Pos:     p,
+	}
+
+	log.Info().Str("fn", out).Int("width", tiles.Bounds().Dx()).Int("height", tiles.Bounds().Dy()).Msg("Rendering")
+
+	if err := warp.EncodeTiled(o, scene, tiles, workers); err != nil {
+		o.Close()
+
+		return err
+	}
+
+	return o.Close()
+}
+
+func savePNG(fn string, m image.Image) error {
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(f, m); err != nil {
+		f.Close()
+
+		return err
+	}
+
+	return f.Close()
+}
+
+// update renders the target from the current state and then
+// moves pos along, so that a saved pos reproduces the same frame
 func update() {
+	bands := make(chan int, h/bandHeight+1)
+
+	for y := 0; y < h; y += bandHeight {
+		bands <- y
+	}
+
+	close(bands)
+
+	// The scene is a snapshot of the state, shared by the workers
+	scene := warp.Scene{
+		Source:  source,
+		Blend:   blend,
+		Noise:   noise,
+		Pattern: pattern,
+		Mode:    mode,
+		Pos:     pos,
+		Field:   field,
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for y := range bands {
+				renderBand(&scene, y, min(y+bandHeight, h))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	frame = scene
+
 	if expand {
 		pos.Y += 0.0025
 		pos.X += 0.0025
@@ -166,121 +515,173 @@ func update() {
 			expand = true
 		}
 	}
+}
+
+// readFrames reads video frames in the background, so that a
+// slow source does not hold up rendering. The channel is closed
+// at the end of the video, leaving the last frame as the source.
+func readFrames() {
+	defer close(sources)
+
+	for {
+		m, err := video.Next()
+		if err != nil {
+			if err != io.EOF {
+				log.Error().Err(err).Msg("video")
+			}
 
-	for x := 0; x < w; x++ {
-		for y := 0; y < h; y++ {
-			target.SetRGBA(x, y, pixelColor(x, y))
+			return
 		}
+
+		sources <- m
 	}
 }
 
-func pattern(p pixel.Vec) (float64, pixel.Vec, pixel.Vec) {
-	q := pixel.V(
-		fbm(p.Add(pixel.V(0.0, 0.0))),
-		fbm(p.Add(pixel.V(5.2, 1.3))),
-	)
+// nextSource replaces the source with the latest video frame, if
+// a new one has been read since the last update
+func nextSource() {
+	if video == nil {
+		return
+	}
 
-	k := pixel.V(
-		fbm(p.Add(q.Scaled(pos.Y).Add(pixel.V(1.7, 9.2)))),
-		fbm(p.Add(q.Scaled(pos.X).Add(pixel.V(8.3, 2.8)))),
-	)
+	select {
+	case m, ok := <-sources:
+		if ok {
+			source = m
+			videoFrame++
+		}
+	default:
+	}
+}
+
+// bandHeight is the number of rows each worker renders at a time
+const bandHeight = 8
 
-	v := fbm(p.Add(k.Scaled(pos.Y * pos.X)))
+// renderBand writes rows y0 to y1 of the target, each band only
+// touches its own part of target.Pix so no locking is needed
+func renderBand(scene *warp.Scene, y0, y1 int) {
+	for y := y0; y < y1; y++ {
+		i := target.PixOffset(0, y)
 
-	return v, q, k
+		for x := 0; x < w; x++ {
+			c := scene.Color(x, y)
+
+			target.Pix[i+0] = c.R
+			target.Pix[i+1] = c.G
+			target.Pix[i+2] = c.B
+			target.Pix[i+3] = c.A
+
+			i += 4
+		}
+	}
 }
 
-func fbm(p pixel.Vec) float64 {
-	return noise.Eval2(p.X, p.Y)
+// State is the configuration saved next to a captured frame,
+// enough to render the exact same frame again using -state
+type State struct {
+	Mode       string    `json:"mode"`
+	Pattern    string    `json:"pattern"`
+	Pos        pixel.Vec `json:"pos"`
+	Expand     bool      `json:"expand"`
+	Seed       int64     `json:"seed"`
+	Scale      float64   `json:"scale"`
+	Image      string    `json:"image"`
+	Blend      string    `json:"blend"`
+	Video      string    `json:"video"`
+	Octaves    int       `json:"octaves"`
+	Lacunarity float64   `json:"lacunarity"`
+	Gain       float64   `json:"gain"`
+
+	// Frame is the number of the video frame that was warped
+	Frame int `json:"frame"`
+
+	// Field is what had been brushed, it is left out when rendering
+	// without a window
+	Field *warp.Field `json:"field,omitempty"`
 }
 
-func warp(fx, fy float64, k pixel.Vec) (int, int) {
-	wx, wy := int(fx*k.X)%w, int(fy*k.Y)%h
+// capture writes the target to a timestamped PNG, along with
+// a JSON file containing the state it was rendered from
+func capture() error {
+	// Render the current state, so that the field
+	// has not been brushed since the target was
+	update()
+
+	s := State{
+		Mode:       frame.Mode.Name,
+		Pattern:    frame.Pattern.Name,
+		Pos:        frame.Pos,
+		Expand:     expand,
+		Seed:       seed,
+		Scale:      scale,
+		Image:      imagePath,
+		Blend:      blendPath,
+		Video:      videoPath,
+		Octaves:    frame.Noise.Octaves,
+		Lacunarity: frame.Noise.Lacunarity,
+		Gain:       frame.Noise.Gain,
+		Frame:      videoFrame,
+		Field:      frame.Field,
+	}
+
+	fn := "warping-" + time.Now().Format("20060102-150405.000")
 
-	if wx < 0 || wx > w {
-		wx = int(fx - k.X)
+	if err := savePNG(fn+".png", target); err != nil {
+		return err
 	}
 
-	if wy < 0 || wy > h {
-		wy = int(fy - k.Y)
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
 	}
 
-	return wx, wy
-}
+	if err := os.WriteFile(fn+".json", b, 0644); err != nil {
+		return err
+	}
 
-func pixelColor(x, y int) color.RGBA {
-	fx, fy := float64(x), float64(y)
+	log.Info().Str("fn", fn).Msg("Captured")
 
-	v, q, k := pattern(pixel.V(fx*0.00123, fy*0.00321))
+	return nil
+}
 
-	wx, wy := warp(fx, fy, k)
+// loadState reads a state saved by capture, on top of the defaults in s
+func loadState(fn string, s State) (State, error) {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return s, err
+	}
 
-	c := source.At(wx, wy).(color.RGBA)
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, err
+	}
 
-	switch mode {
-	case 1: // Source warped by pattern
-		c = source.At(wx, wy).(color.RGBA)
-	case 2: // Source warped by pattern, glowing edges
-		if v > 0.1 {
-			c = source.At(x, y).(color.RGBA)
-		} else if v > -0.1 {
-			c = color.RGBA{c.R, c.G / 2, c.B / 4, 25}
-		}
-	case 3: // Grayscale
-		// Weighted luminosity average for human perception as per the GIMP docs:
-		// https://docs.gimp.org/2.8/en/gimp-tool-desaturate.html
-		a := uint8(float64(c.R)*0.21 + float64(c.G)*0.72 + float64(c.B)*0.07)
-
-		c = color.RGBA{a, a, a, 255}
-	case 4: // Black and white
-		uv := uint8(int(v*255) % 255)
-
-		switch {
-		case uv > 127:
-			c = color.RGBA{255, 255, 255, 255}
-		default:
-			c = color.RGBA{0, 0, 0, 255}
-		}
-	case 5: // See through black and white
-		uv := uint8(int(v*255) % 255)
+	switch {
+	case s.Scale <= 0:
+		return s, fmt.Errorf("%s: scale %g is not positive", fn, s.Scale)
+	case s.Frame < 0:
+		return s, fmt.Errorf("%s: frame %d is negative", fn, s.Frame)
+	}
 
-		switch {
-		case uv > 32 && uv < 128:
-			a := uint8(float64(c.R)*0.21 + float64(c.G)*0.72 + float64(c.B)*0.07)
+	return s, nil
+}
 
-			c = color.RGBA{a, a, a, 255}
-		case uv > 64:
-			c = color.RGBA{255, 255, 255, 255}
-		default:
-			c = color.RGBA{0, 0, 0, 255}
-		}
-	case 6: // Black for now
-		c = color.RGBA{0, 0, 0, 255}
-	case 7: // Black for now
-		c = color.RGBA{0, 0, 0, 255}
-	case 8: // Black for now
-		c = color.RGBA{0, 0, 0, 255}
-	case 9: // Experimental
-		c = source.At(x, y).(color.RGBA)
-		a := uint8(float64(c.R)*0.21 + float64(c.G)*0.72 + float64(c.B)*0.07)
-
-		switch {
-		case q.Y > 0 && v > 0:
-			c = color.RGBA{255, a, a, 255}
-		case q.Y < 0 && v < 0:
-			c = color.RGBA{0, a, 255, 255}
-		case q.X > 0 && k.X < 0:
-			c = color.RGBA{255, 255, a, 255}
-		case q.X > 0 && k.Y > 0:
-			c = color.RGBA{a, 255, 255, 255}
-		default:
-			c = color.RGBA{a, a, a, 255}
-		}
-	case 0: // Source image without any warping
-		c = source.At(x, y).(color.RGBA)
+// absPath makes fn absolute, so that a saved state
+// can be loaded from any directory
+func absPath(fn string) string {
+	if fn == "" {
+		return ""
+	}
+
+	if abs, err := filepath.Abs(fn); err == nil {
+		return abs
 	}
 
-	return c
+	return fn
+}
+
+// mousePosition returns the pixel in the target under the mouse
+func mousePosition(win *pixelgl.Window) pixel.Vec {
+	return matrix.Unproject(win.MousePosition()).Add(bounds.Center())
 }
 
 func processInput(win *pixelgl.Window) {
@@ -288,59 +689,62 @@ func processInput(win *pixelgl.Window) {
 
 	for _, button := range pressedButtons {
 		if win.Pressed(button) {
-			input <- button
+			handleButton(button)
 		}
 	}
 
 	for _, button := range justPressedButtons {
 		if win.JustPressed(button) {
-			input <- button
+			handleButton(button)
 		}
 	}
 }
 
-func handleInput() {
-	for button := range input {
-		switch button {
-		case pixelgl.Key0:
-			mode = 0
-		case pixelgl.Key1:
-			mode = 1
-		case pixelgl.Key2:
-			mode = 2
-		case pixelgl.Key3:
-			mode = 3
-		case pixelgl.Key4:
-			mode = 4
-		case pixelgl.Key5:
-			mode = 5
-		case pixelgl.Key6:
-			mode = 6
-		case pixelgl.Key7:
-			mode = 7
-		case pixelgl.Key8:
-			mode = 8
-		case pixelgl.Key9:
-			mode = 9
-		case pixelgl.KeyUp:
-			pos.Y += 0.05
-			expand = true
-		case pixelgl.KeyDown:
-			pos.Y -= 0.05
-			expand = false
-		case pixelgl.KeyLeft:
-			pos.X += 0.05
-			expand = true
-		case pixelgl.KeyRight:
-			pos.X -= 0.05
-			expand = false
-		case pixelgl.KeyS:
-			pos.X = 0
-			pos.Y = 0
-		}
-
-		logState()
+// handleButton changes the state for a pressed button, it is called from
+// the main loop since the state is read by update and logState there
+func handleButton(button pixelgl.Button) {
+	switch button {
+	case pixelgl.Key0, pixelgl.Key1, pixelgl.Key2, pixelgl.Key3, pixelgl.Key4,
+		pixelgl.Key5, pixelgl.Key6, pixelgl.Key7, pixelgl.Key8, pixelgl.Key9:
+		selectMode(fmt.Sprint(int(button - pixelgl.Key0)))
+	case pixelgl.KeyMinus:
+		cycleMode(-1)
+	case pixelgl.KeyEqual:
+		cycleMode(1)
+	case pixelgl.KeyTab:
+		cyclePattern(1)
+	case pixelgl.KeyUp:
+		pos.Y += 0.05
+		expand = true
+	case pixelgl.KeyDown:
+		pos.Y -= 0.05
+		expand = false
+	case pixelgl.KeyLeft:
+		pos.X += 0.05
+		expand = true
+	case pixelgl.KeyRight:
+		pos.X -= 0.05
+		expand = false
+	case pixelgl.KeyS:
+		pos.X = 0
+		pos.Y = 0
+	case pixelgl.KeyO:
+		noise.Octaves = max(noise.Octaves-1, 1)
+	case pixelgl.KeyP:
+		noise.Octaves = min(noise.Octaves+1, 10)
+	case pixelgl.KeyN:
+		noise.Lacunarity -= 0.05
+	case pixelgl.KeyM:
+		noise.Lacunarity += 0.05
+	case pixelgl.KeyG:
+		noise.Gain -= 0.05
+	case pixelgl.KeyH:
+		noise.Gain += 0.05
 	}
+
+	clampNoise()
+
+	logState()
 }
 
 var pressedButtons = []pixelgl.Button{
@@ -363,65 +767,94 @@ var justPressedButtons = []pixelgl.Button{
 	pixelgl.Key9,
 	pixelgl.KeyL,
 	pixelgl.KeyS,
+	pixelgl.KeyO,
+	pixelgl.KeyP,
+	pixelgl.KeyN,
+	pixelgl.KeyM,
+	pixelgl.KeyG,
+	pixelgl.KeyH,
+	pixelgl.KeyMinus,
+	pixelgl.KeyEqual,
+	pixelgl.KeyTab,
+}
+
+// clampNoise keeps the fbm settings where every octave adds a finite,
+// smaller amount of detail, a gain of zero or less can make the
+// amplitudes sum to zero and FBM return NaN
+func clampNoise() {
+	noise.Octaves = min(max(noise.Octaves, 1), 10)
+	noise.Lacunarity = math.Max(noise.Lacunarity, 0.05)
+	noise.Gain = math.Min(math.Max(noise.Gain, 0.05), 1)
 }
 
 func logState() {
 	log.Info().
-		Int("mode", mode).
+		Int("mode", mode.Index).
+		Str("name", mode.Name).
+		Str("pattern", pattern.Name).
 		Interface("pos", pos).
+		Int("octaves", noise.Octaves).
+		Float64("lacunarity", noise.Lacunarity).
+		Float64("gain", noise.Gain).
 		Msg("State")
 }
 
-func loadImage(fn string) (*image.RGBA, error) {
-	if fn == "" {
-		return xorImage(400, 300), nil
+// selectMode selects a registered mode by name or number
+func selectMode(s string) error {
+	m, ok := warp.LookupMode(s)
+	if !ok {
+		return fmt.Errorf("unknown mode %q", s)
 	}
 
-	f, err := os.Open(fn)
-	if err != nil {
-		return nil, err
-	}
+	mode = m
 
-	m, _, err := image.Decode(f)
-	if err != nil {
-		return nil, err
-	}
+	return nil
+}
 
-	if rgba, ok := m.(*image.RGBA); ok {
-		return rgba, nil
+// selectPattern selects a registered pattern by name
+func selectPattern(s string) error {
+	p, ok := warp.LookupPattern(s)
+	if !ok {
+		return fmt.Errorf("unknown pattern %q", s)
 	}
 
-	b := m.Bounds()
+	pattern = p
 
-	rgba := image.NewRGBA(b)
+	return nil
+}
 
-	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x := b.Min.X; x < b.Max.X; x++ {
-			rgba.Set(x, y, m.At(x, y))
-		}
-	}
+// cycleMode selects the mode d steps away from the current one
+func cycleMode(d int) {
+	modes := warp.Modes()
 
-	return rgba, nil
+	mode = modes[((mode.Index+d)%len(modes)+len(modes))%len(modes)]
 }
 
-func xorImage(w, h int) *image.RGBA {
-	m := image.NewRGBA(image.Rect(0, 0, w, h))
+// cyclePattern selects the pattern d steps away from the current one
+func cyclePattern(d int) {
+	patterns := warp.Patterns()
 
-	for x := 0; x < w; x++ {
-		for y := 0; y < h; y++ {
-			v := uint8(x ^ y)
+	for i, p := range patterns {
+		if p.Name == pattern.Name {
+			pattern = patterns[((i+d)%len(patterns)+len(patterns))%len(patterns)]
 
-			c := color.RGBA{v, v % 128, v, 255}
+			return
+		}
+	}
+}
 
-			if x >= w/2 {
-				c = color.RGBA{v, v, v % 128, 255}
-			}
+func printList() {
+	fmt.Println("Patterns:")
 
-			m.Set(x, y, c)
-		}
+	for _, p := range warp.Patterns() {
+		fmt.Printf("  %-16s %s\n", p.Name, p.Description)
 	}
 
-	return m
+	fmt.Println("\nModes:")
+
+	for _, m := range warp.Modes() {
+		fmt.Printf("  %2d %-16s %s\n", m.Index, m.Name, m.Description)
+	}
 }
 
 func max(a, b int) int {
@@ -431,3 +864,11 @@ func max(a, b int) int {
 
 	return b
 }
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
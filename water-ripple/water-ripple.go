@@ -1,35 +1,24 @@
 package main
 
 import (
+	"flag"
 	"image"
 	"image/color"
+	"image/draw"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/faiface/pixel"
 	"github.com/faiface/pixel/pixelgl"
 )
 
-const (
-	width  = 512
-	height = 512
-	size   = width * (height + 2) * 2
-	delay  = 100 * time.Millisecond
-
-	halfWidth  = width >> 1
-	halfHeight = height >> 1
-)
+const delay = 100 * time.Millisecond
 
 var (
-	oldIdx    = width
-	newIdx    = width * (height + 3)
-	rippleRad = 3
-
-	texture = xorImage(width, height)
-	ripple  = xorImage(width, height)
-
-	rippleMap = make([]int, size)
-	lastMap   = make([]int, size)
+	width, height int
+	damping       uint
+	rippleRad     int
 )
 
 func run() {
@@ -43,12 +32,14 @@ func run() {
 		panic(err)
 	}
 
+	r := NewRipple(xorImage(width, height), damping)
+
 	ticker := time.NewTicker(delay)
 
 	go func() {
 		for range ticker.C {
 			if rand.Intn(2) == 1 {
-				dropAt(rand.Intn(width), rand.Intn(height))
+				r.Drop(rand.Intn(width), rand.Intn(height), rippleRad, 512)
 			}
 		}
 	}()
@@ -56,16 +47,16 @@ func run() {
 	c := win.Bounds().Center()
 
 	for !win.Closed() {
-		newRippleFrame()
+		r.Step()
 
-		p := pixel.PictureDataFromImage(ripple)
+		p := pixel.PictureDataFromImage(r.Image())
 		s := pixel.NewSprite(p, p.Bounds())
 
 		s.Draw(win, pixel.IM.Moved(c))
 
 		mouse := win.MousePosition()
 
-		dropAt(int(mouse.X), int(height-mouse.Y))
+		r.Drop(int(mouse.X), int(float64(height)-mouse.Y), rippleRad, 512)
 
 		if win.JustPressed(pixelgl.KeyEscape) || win.JustPressed(pixelgl.KeyQ) {
 			return
@@ -76,27 +67,93 @@ func run() {
 }
 
 func main() {
+	flag.IntVar(&width, "width", 512, "width of the water")
+	flag.IntVar(&height, "height", 512, "height of the water")
+	flag.UintVar(&damping, "damping", 5, "the waves lose 1/2^damping of their height every step")
+	flag.IntVar(&rippleRad, "radius", 3, "radius of the drops")
+	flag.Parse()
+
 	pixelgl.Run(run)
 }
 
-func dropAt(dx, dy int) {
-	if dx > 1 && dy > 1 && dx < width && dy < height-rippleRad {
-		for j := dy - rippleRad; j < dy+rippleRad; j++ {
-			for k := dx - rippleRad; k < dx+rippleRad; k++ {
-				rippleMap[oldIdx+(j*width)+k] += 512
+// Ripple is a water surface refracting a texture. Drops can be
+// added from any goroutine while the surface is being stepped.
+type Ripple struct {
+	mu sync.Mutex
+
+	width, height int
+	damping       uint
+
+	// Two height maps, each with an empty row above and below,
+	// oldIdx and newIdx are where the first row of each starts
+	rippleMap      []int
+	lastMap        []int
+	oldIdx, newIdx int
+
+	texture *image.RGBA
+	image   *image.RGBA
+}
+
+// NewRipple returns still water the size of texture, where the waves
+// lose 1/2^damping of their height every step
+func NewRipple(texture *image.RGBA, damping uint) *Ripple {
+	w, h := texture.Rect.Dx(), texture.Rect.Dy()
+
+	m := image.NewRGBA(image.Rect(0, 0, w, h))
+
+	draw.Draw(m, m.Rect, texture, texture.Rect.Min, draw.Src)
+
+	return &Ripple{
+		width:     w,
+		height:    h,
+		damping:   damping,
+		rippleMap: make([]int, w*(h+2)*2),
+		lastMap:   make([]int, w*h),
+		oldIdx:    w,
+		newIdx:    w * (h + 3),
+		texture:   m,
+		image:     image.NewRGBA(m.Rect),
+	}
+}
+
+// Image returns the refracted texture, it is only updated by Step
+func (r *Ripple) Image() *image.RGBA {
+	return r.image
+}
+
+// Drop raises the water within radius of x, y by strength,
+// the parts of the drop outside of the surface are ignored
+func (r *Ripple) Drop(x, y, radius, strength int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for j := y - radius; j < y+radius; j++ {
+		for k := x - radius; k < x+radius; k++ {
+			if j < 0 || k < 0 || j >= r.height || k >= r.width {
+				continue
 			}
+
+			r.rippleMap[r.oldIdx+(j*r.width)+k] += strength
 		}
 	}
 }
 
-func newRippleFrame() {
-	i := oldIdx
+// Step spreads the waves and refracts the texture into the image
+func (r *Ripple) Step() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var (
+		width, height = r.width, r.height
+		halfWidth     = width >> 1
+		halfHeight    = height >> 1
+		rippleMap     = r.rippleMap
+	)
 
-	oldIdx = newIdx
-	newIdx = i
+	r.oldIdx, r.newIdx = r.newIdx, r.oldIdx
 
-	i = 0
-	mapIdx := oldIdx
+	i := 0
+	mapIdx := r.oldIdx
 
 	var data, oldData int
 
@@ -107,19 +164,19 @@ func newRippleFrame() {
 				rippleMap[mapIdx-1] +
 				rippleMap[mapIdx+1]) >> 1
 
-			data -= rippleMap[newIdx+i]
-			data -= data >> 5
+			data -= rippleMap[r.newIdx+i]
+			data -= data >> r.damping
 
-			rippleMap[newIdx+i] = data
+			rippleMap[r.newIdx+i] = data
 
 			data = 1024 - data
 
-			oldData = lastMap[i]
-			lastMap[i] = data
+			oldData = r.lastMap[i]
+			r.lastMap[i] = data
 
 			if oldData != data {
-				a := (((x - halfWidth) * data / 1024) << 0) + halfWidth
-				b := (((y - halfHeight) * data / 1024) << 0) + halfHeight
+				a := ((x - halfWidth) * data / 1024) + halfWidth
+				b := ((y - halfHeight) * data / 1024) + halfHeight
 
 				if a >= width {
 					a = width - 1
@@ -140,9 +197,10 @@ func newRippleFrame() {
 				newPixel := (a + (b * width)) * 4
 				curPixel := i * 4
 
-				ripple.Pix[curPixel] = texture.Pix[newPixel]
-				ripple.Pix[curPixel+1] = texture.Pix[newPixel+1]
-				ripple.Pix[curPixel+2] = texture.Pix[newPixel+2]
+				r.image.Pix[curPixel] = r.texture.Pix[newPixel]
+				r.image.Pix[curPixel+1] = r.texture.Pix[newPixel+1]
+				r.image.Pix[curPixel+2] = r.texture.Pix[newPixel+2]
+				r.image.Pix[curPixel+3] = r.texture.Pix[newPixel+3]
 			}
 
 			mapIdx++
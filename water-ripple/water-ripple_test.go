@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// waterHeight returns the sum of the absolute heights of the water
+func waterHeight(r *Ripple) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sum int
+
+	for _, v := range r.rippleMap {
+		if v < 0 {
+			v = -v
+		}
+
+		sum += v
+	}
+
+	return sum
+}
+
+func TestRippleEnergyDecays(t *testing.T) {
+	r := NewRipple(xorImage(64, 64), 5)
+
+	r.Drop(32, 32, 3, 512)
+
+	// The waves spread out before they die down, so compare the
+	// highest the water gets within each window of steps
+	const window = 50
+
+	last := -1
+
+	for n := 0; n < 20; n++ {
+		peak := 0
+
+		for s := 0; s < window; s++ {
+			r.Step()
+
+			if h := waterHeight(r); h > peak {
+				peak = h
+			}
+		}
+
+		if last >= 0 && peak >= last && peak > 0 {
+			t.Fatalf("height %d after %d steps, up from %d", peak, (n+1)*window, last)
+		}
+
+		if peak == 0 {
+			break
+		}
+
+		last = peak
+	}
+
+	if h := waterHeight(r); h != 0 {
+		t.Fatalf("height %d, expected the water to be still", h)
+	}
+}
+
+func TestRippleDropWhileStepping(t *testing.T) {
+	r := NewRipple(xorImage(64, 48), 5)
+
+	var wg sync.WaitGroup
+
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+
+		go func(g int) {
+			defer wg.Done()
+
+			for n := 0; n < 500; n++ {
+				// Some of the drops are partly or fully outside of the water
+				r.Drop(n%80-8, (n*g)%60-6, 3, 512)
+			}
+		}(g)
+	}
+
+	for n := 0; n < 200; n++ {
+		r.Step()
+	}
+
+	wg.Wait()
+}